@@ -0,0 +1,257 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// KindAssumeRole is the kind of AssumeRoleProfile profiles.
+const KindAssumeRole = "assume-role"
+
+// AssumeRoleProfile is a profile which assumes an IAM role using the credentials of another profile,
+// such as a generated SSO profile. It is written with role_arn and source_profile.
+type AssumeRoleProfile struct {
+	// AccountName, AccountID and RoleName describe the role being assumed.
+	// They are available to the profile name template, like the fields of AccountProfile.
+	AccountName string
+	AccountID   string
+	RoleName    string
+	// RoleARN is the ARN of the role to assume. If empty, it is built from
+	// the partition, account ID and role name.
+	RoleARN string
+	// Partition is the AWS partition of the role. Defaults to aws.
+	Partition string
+	// SourceAccountID and SourceRoleName identify the generated profile whose credentials are used
+	// to assume the role. It can be an AccountProfile or another AssumeRoleProfile.
+	// source_profile is set to the name the profile was generated with.
+	SourceAccountID string
+	SourceRoleName  string
+	// SourceProfile is the name of the profile whose credentials are used to assume the role,
+	// for profiles which aren't generated. It is ignored if SourceAccountID is set.
+	SourceProfile   string
+	ExternalID      string
+	MFASerial       string
+	RoleSessionName string
+	DurationSeconds int
+	Region          string
+	GeneratedFrom   string
+	SourceID        string
+}
+
+type assumeRoleProfile struct {
+	RoleARN                 string `ini:"role_arn"`
+	SourceProfile           string `ini:"source_profile"`
+	ExternalID              string `ini:"external_id,omitempty"`
+	MFASerial               string `ini:"mfa_serial,omitempty"`
+	RoleSessionName         string `ini:"role_session_name,omitempty"`
+	DurationSeconds         int    `ini:"duration_seconds,omitempty"`
+	CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
+	Region                  string `ini:"region,omitempty"`
+}
+
+func (a *AssumeRoleProfile) ToIni(profileName string, noCredentialProcess bool) any {
+	return &assumeRoleProfile{
+		RoleARN:                 a.roleARN(),
+		SourceProfile:           a.SourceProfile,
+		ExternalID:              a.ExternalID,
+		MFASerial:               a.MFASerial,
+		RoleSessionName:         a.RoleSessionName,
+		DurationSeconds:         a.DurationSeconds,
+		CommonFateGeneratedFrom: a.GeneratedFrom,
+		CommonFateSource:        a.SourceID,
+		Region:                  a.Region,
+	}
+}
+
+func (a *AssumeRoleProfile) roleARN() string {
+	if a.RoleARN != "" {
+		return a.RoleARN
+	}
+	partition := a.Partition
+	if partition == "" {
+		partition = "aws"
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, a.AccountID, a.RoleName)
+}
+
+func (a *AssumeRoleProfile) validate() error {
+	if a.RoleARN == "" && (a.AccountID == "" || a.RoleName == "") {
+		return fmt.Errorf("%s profile needs a role ARN, or an account ID and role name", KindAssumeRole)
+	}
+	if a.SourceAccountID == "" && a.SourceProfile == "" {
+		return fmt.Errorf("%s profile for %s has no source profile", KindAssumeRole, a.roleARN())
+	}
+	return nil
+}
+
+func (a *AssumeRoleProfile) WithSourceID(sourceID string) Profile {
+	c := *a
+	if c.SourceID == "" {
+		c.SourceID = sourceID
+	}
+	return &c
+}
+
+func (a *AssumeRoleProfile) normalized() Profile {
+	c := *a
+	c.AccountName = normalizeAccountName(c.AccountName)
+	return &c
+}
+
+func (a *AssumeRoleProfile) Kind() string {
+	return KindAssumeRole
+}
+
+func (a *AssumeRoleProfile) SectionName(profileName string) string {
+	return accountSectionName(profileName)
+}
+
+func (a *AssumeRoleProfile) ref() profileRef {
+	return profileRef{AccountID: a.AccountID, RoleName: a.RoleName}
+}
+
+func (a *AssumeRoleProfile) sourceRef() (profileRef, bool) {
+	if a.SourceAccountID == "" {
+		return profileRef{}, false
+	}
+	return profileRef{AccountID: a.SourceAccountID, RoleName: a.SourceRoleName}, true
+}
+
+func (a *AssumeRoleProfile) withSourceProfile(profileName string) Profile {
+	c := *a
+	c.SourceProfile = profileName
+	return &c
+}
+
+// sourceProfileSection returns the section named by the source_profile key in sec, if there is one.
+func sourceProfileSection(cfg *ini.File, sec *ini.Section) (*ini.Section, bool) {
+	name := keyValue(sec, "source_profile")
+	if name == "" {
+		return nil, false
+	}
+	source, err := cfg.GetSection(sourceProfileSectionName(name))
+	if err != nil {
+		return nil, false
+	}
+	return source, true
+}
+
+// sourceProfileSectionName returns the name of the section a source_profile refers to.
+func sourceProfileSectionName(profileName string) string {
+	// the default profile is written as [default] rather than [profile default]
+	if profileName == "default" {
+		return profileName
+	}
+	return accountSectionName(profileName)
+}
+
+// checkSourceProfileCycles returns an error if following source_profile from any of the pending sections
+// leads back to a section it has already passed through. It is checked before anything is written.
+func checkSourceProfileCycles(pending *pendingSections) error {
+	for _, sectionName := range pending.sectionNames() {
+		chain := []string{sectionName}
+		seen := map[string]bool{sectionName: true}
+		for name := sectionName; ; {
+			source := pending.sourceProfile(name)
+			if source == "" {
+				break
+			}
+			name = sourceProfileSectionName(source)
+			chain = append(chain, name)
+			if seen[name] {
+				return fmt.Errorf("source_profile cycle: %s", strings.Join(chain, " -> "))
+			}
+			seen[name] = true
+		}
+	}
+	return nil
+}
+
+// AssumeRoleChain describes a role to assume in each account returned by the sources.
+// It is used with AssumeRoleChains to generate AssumeRoleProfiles.
+type AssumeRoleChain struct {
+	// RoleName is the IAM role to assume in each account.
+	RoleName string
+	// SourceAccountID and SourceRoleName identify the generated profile whose credentials are used
+	// to assume the role. If SourceAccountID is empty, the profile for SourceRoleName
+	// in the same account is used, and accounts without it are skipped.
+	SourceAccountID string
+	SourceRoleName  string
+	// AccountIDs restricts the chain to the given accounts. If empty, it applies to every account.
+	AccountIDs      []string
+	ExternalID      string
+	MFASerial       string
+	RoleSessionName string
+	DurationSeconds int
+}
+
+// AssumeRoleChains returns an AssumeRoleProfile for each chain in each account
+// among the account profiles, in addition to the given profiles.
+func AssumeRoleChains(profiles []SSOProfile, chains []AssumeRoleChain) []SSOProfile {
+	type account struct {
+		profile *AccountProfile
+		roles   map[string]bool
+	}
+	var order []string
+	accounts := make(map[string]*account)
+	for _, p := range profiles {
+		a, ok := p.(*AccountProfile)
+		if !ok {
+			continue
+		}
+		if _, ok := accounts[a.AccountID]; !ok {
+			order = append(order, a.AccountID)
+			accounts[a.AccountID] = &account{profile: a, roles: make(map[string]bool)}
+		}
+		accounts[a.AccountID].roles[a.RoleName] = true
+	}
+
+	result := append([]SSOProfile{}, profiles...)
+	for _, chain := range chains {
+		for _, id := range order {
+			acct := accounts[id]
+			if len(chain.AccountIDs) > 0 && !slices.Contains(chain.AccountIDs, id) {
+				continue
+			}
+			sourceAccountID := chain.SourceAccountID
+			if sourceAccountID == "" {
+				if !acct.roles[chain.SourceRoleName] {
+					continue
+				}
+				sourceAccountID = id
+			}
+			if sourceAccountID == id && chain.SourceRoleName == chain.RoleName {
+				continue
+			}
+			result = append(result, &AssumeRoleProfile{
+				AccountName:     acct.profile.AccountName,
+				AccountID:       id,
+				RoleName:        chain.RoleName,
+				Partition:       startURLPartition(acct.profile.SSOStartURL),
+				SourceAccountID: sourceAccountID,
+				SourceRoleName:  chain.SourceRoleName,
+				ExternalID:      chain.ExternalID,
+				MFASerial:       chain.MFASerial,
+				RoleSessionName: chain.RoleSessionName,
+				DurationSeconds: chain.DurationSeconds,
+				Region:          acct.profile.Region,
+				GeneratedFrom:   acct.profile.GeneratedFrom,
+				SourceID:        acct.profile.SourceID,
+			})
+		}
+	}
+	return result
+}
+
+// startURLPartition returns the partition of the start URL, or an empty string if it isn't known.
+func startURLPartition(startURL string) string {
+	u, err := ParseStartURL(startURL)
+	if err != nil {
+		return ""
+	}
+	return u.Partition
+}
@@ -0,0 +1,301 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_AssumeRoleProfile(t *testing.T) {
+	sso := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "111111111111",
+		AccountName:   "management",
+		RoleName:      "Admin",
+		GeneratedFrom: "aws-sso",
+	}
+
+	tests := []struct {
+		name     string
+		profiles []SSOProfile
+		config   string
+		prune    bool
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "source profile is resolved to the generated name",
+			profiles: []SSOProfile{
+				sso,
+				&AssumeRoleProfile{
+					AccountName:     "workload",
+					AccountID:       "222222222222",
+					RoleName:        "Deploy",
+					SourceAccountID: "111111111111",
+					SourceRoleName:  "Admin",
+					ExternalID:      "abc",
+					RoleSessionName: "deploy",
+					DurationSeconds: 3600,
+					GeneratedFrom:   "aws-sso",
+				},
+			},
+			want: `
+[profile cf-management/Admin]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 111111111111
+granted_sso_role_name      = Admin
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile cf-management/Admin
+common_fate_format_version = 1
+
+[profile cf-workload/Deploy]
+role_arn                   = arn:aws:iam::222222222222:role/Deploy
+source_profile             = cf-management/Admin
+external_id                = abc
+role_session_name          = deploy
+duration_seconds           = 3600
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+common_fate_generated_keys = role_arn,source_profile,external_id,role_session_name,duration_seconds
+`,
+		},
+		{
+			name: "chained assume role profiles",
+			profiles: []SSOProfile{
+				&AssumeRoleProfile{
+					AccountName:     "nested",
+					AccountID:       "333333333333",
+					RoleName:        "ReadOnly",
+					RoleARN:         "arn:aws:iam::333333333333:role/path/ReadOnly",
+					SourceAccountID: "222222222222",
+					SourceRoleName:  "Deploy",
+					GeneratedFrom:   "aws-sso",
+				},
+				&AssumeRoleProfile{
+					AccountName:   "workload",
+					AccountID:     "222222222222",
+					RoleName:      "Deploy",
+					SourceProfile: "base",
+					GeneratedFrom: "aws-sso",
+				},
+			},
+			want: `
+[profile cf-nested/ReadOnly]
+role_arn                   = arn:aws:iam::333333333333:role/path/ReadOnly
+source_profile             = cf-workload/Deploy
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+common_fate_generated_keys = role_arn,source_profile
+
+[profile cf-workload/Deploy]
+role_arn                   = arn:aws:iam::222222222222:role/Deploy
+source_profile             = base
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+common_fate_generated_keys = role_arn,source_profile
+`,
+		},
+		{
+			name: "cycles are an error",
+			profiles: []SSOProfile{
+				&AssumeRoleProfile{AccountName: "a", AccountID: "1", RoleName: "A", SourceAccountID: "2", SourceRoleName: "B", GeneratedFrom: "aws-sso"},
+				&AssumeRoleProfile{AccountName: "b", AccountID: "2", RoleName: "B", SourceAccountID: "1", SourceRoleName: "A", GeneratedFrom: "aws-sso"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cycles through hand-written profiles are an error",
+			profiles: []SSOProfile{
+				&AssumeRoleProfile{AccountName: "a", AccountID: "1", RoleName: "A", SourceProfile: "manual", GeneratedFrom: "aws-sso"},
+			},
+			config: `
+[profile manual]
+role_arn       = arn:aws:iam::1:role/A
+source_profile = cf-a/A
+`,
+			wantErr: true,
+		},
+		{
+			name: "profiles with missing source profiles are skipped",
+			profiles: []SSOProfile{
+				&AssumeRoleProfile{AccountName: "a", AccountID: "1", RoleName: "A", SourceAccountID: "2", SourceRoleName: "B", GeneratedFrom: "aws-sso"},
+			},
+			want: ``,
+		},
+		{
+			name: "profiles without a source are an error",
+			profiles: []SSOProfile{
+				&AssumeRoleProfile{AccountName: "a", AccountID: "1", RoleName: "A", GeneratedFrom: "aws-sso"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "profiles without a role are an error",
+			profiles: []SSOProfile{
+				&AssumeRoleProfile{AccountName: "a", AccountID: "1", SourceProfile: "base", GeneratedFrom: "aws-sso"},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "profiles chained from pruned profiles are pruned",
+			prune: true,
+			config: `
+[profile cf-management/Admin]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 111111111111
+granted_sso_role_name      = Admin
+common_fate_generated_from = aws-sso
+
+[profile cf-workload/Deploy]
+role_arn                   = arn:aws:iam::222222222222:role/Deploy
+source_profile             = cf-management/Admin
+common_fate_generated_from = aws-sso
+`,
+			want: ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			opts := MergeOpts{
+				Config:   cfg,
+				Profiles: tt.profiles,
+				Prefix:   "cf-",
+			}
+			if tt.prune {
+				opts.PruneStartURLs = []string{"https://example.awsapps.com/start"}
+			}
+			err := Merge(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// nothing is written when the merge fails
+				assertIni(t, cfg, tt.config)
+				return
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
+
+func TestAssumeRoleChains(t *testing.T) {
+	management := &AccountProfile{AccountID: "111111111111", AccountName: "management", RoleName: "Admin", SSOStartURL: "https://example.awsapps.cn/start"}
+	workload := &AccountProfile{AccountID: "222222222222", AccountName: "workload", RoleName: "ReadOnly"}
+	profiles := []SSOProfile{management, workload}
+
+	got := AssumeRoleChains(profiles, []AssumeRoleChain{
+		{RoleName: "OrganizationAccountAccessRole", SourceAccountID: "111111111111", SourceRoleName: "Admin"},
+		{RoleName: "Deploy", SourceRoleName: "ReadOnly"},
+	})
+
+	assert.Equal(t, []SSOProfile{
+		management,
+		workload,
+		&AssumeRoleProfile{AccountName: "management", AccountID: "111111111111", RoleName: "OrganizationAccountAccessRole", Partition: "aws-cn", SourceAccountID: "111111111111", SourceRoleName: "Admin"},
+		&AssumeRoleProfile{AccountName: "workload", AccountID: "222222222222", RoleName: "OrganizationAccountAccessRole", SourceAccountID: "111111111111", SourceRoleName: "Admin"},
+		&AssumeRoleProfile{AccountName: "workload", AccountID: "222222222222", RoleName: "Deploy", SourceAccountID: "222222222222", SourceRoleName: "ReadOnly"},
+	}, got)
+}
+
+func TestMerge_AssumeRoleKeysOnOtherProfiles(t *testing.T) {
+	profile := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "testing",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+	cfg := parseIni(t, `
+[profile _base]
+duration_seconds = 3600
+
+[profile testing/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile testing/DevRole
+duration_seconds           = 43200
+role_session_name          = me
+common_fate_format_version = 1
+`)
+
+	// keys which only assume-role profiles generate can be added to SSO profiles by hand
+	err := Merge(MergeOpts{Config: cfg, Profiles: []SSOProfile{profile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "43200", cfg.Section("profile testing/DevRole").Key("duration_seconds").String())
+	assert.Equal(t, "me", cfg.Section("profile testing/DevRole").Key("role_session_name").String())
+
+	// and inherited from a base profile
+	cfg.Section("profile testing/DevRole").DeleteKey("duration_seconds")
+	err = Merge(MergeOpts{Config: cfg, Profiles: []SSOProfile{profile}, BaseProfile: "_base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3600", cfg.Section("profile testing/DevRole").Key("duration_seconds").String())
+}
+
+func TestMerge_AssumeRoleChainSourceNotGenerated(t *testing.T) {
+	profiles := func() []SSOProfile {
+		sso := &AccountProfile{
+			SSOStartURL:   "https://example.awsapps.com/start",
+			AccountID:     "111111111111",
+			AccountName:   "prod",
+			RoleName:      "Admin",
+			GeneratedFrom: "aws-sso",
+		}
+		return AssumeRoleChains([]SSOProfile{sso}, []AssumeRoleChain{
+			{RoleName: "Deploy", SourceRoleName: "Admin"},
+			{RoleName: "Release", SourceAccountID: "111111111111", SourceRoleName: "Deploy"},
+		})
+	}
+	tests := []struct {
+		name      string
+		config    string
+		policy    ConflictPolicy
+		overrides []ProfileOverride
+		want      string
+	}{
+		{
+			name:   "source skipped because of a conflict",
+			policy: ConflictSkip,
+			config: `
+[profile prod/Admin]
+region = eu-west-1
+`,
+			want: `
+[profile prod/Admin]
+region = eu-west-1
+`,
+		},
+		{
+			name:      "source suppressed by an override",
+			overrides: []ProfileOverride{{AccountID: "111111111111", RoleName: "Admin", Suppress: true}},
+			want:      ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			report, err := MergeWithReport(MergeOpts{
+				Config:         cfg,
+				Profiles:       profiles(),
+				ConflictPolicy: tt.policy,
+				Overrides:      tt.overrides,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertIni(t, cfg, tt.want)
+			var skipped []string
+			for _, s := range report.Skipped {
+				skipped = append(skipped, s.ProfileName)
+			}
+			// profiles chained from a skipped profile are skipped too
+			assert.Equal(t, []string{"prod/Deploy", "prod/Release"}, skipped)
+		})
+	}
+}
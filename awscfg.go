@@ -4,12 +4,13 @@ package awsconfigfile
 
 import (
 	"bytes"
-	"slices"
+	"errors"
+	"fmt"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
-	"github.com/dlclark/regexp2"
 	"github.com/Masterminds/sprig/v3"
 	"github.com/common-fate/clio"
 	"gopkg.in/ini.v1"
@@ -25,6 +26,8 @@ type SSOSession struct {
 	SSORegistrationScopes   string
 	SSORegion               string
 	GeneratedFrom string
+	// SourceID identifies the source which returned the session, if the source has an ID.
+	SourceID string
 }
 
 type ssoSession struct {
@@ -32,6 +35,7 @@ type ssoSession struct {
 	SSORegistrationScopes   string `ini:"sso_registration_scopes"`
 	SSORegion               string `ini:"sso_region"`
 	CommonFateGeneratedFrom string `ini:"common_fate_generated_from,omitempty"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
 }
 
 func (s *SSOSession) ToIni(profileName string, nocredentialProcessProfile bool) any {
@@ -40,17 +44,35 @@ func (s *SSOSession) ToIni(profileName string, nocredentialProcessProfile bool)
 		SSORegistrationScopes: s.SSORegistrationScopes,
 		SSORegion:             s.SSORegion,
 		CommonFateGeneratedFrom: s.GeneratedFrom,
+		CommonFateSource:        s.SourceID,
 	}
 }
 
 type AccountProfile struct {
 	AccountName    string
+	// SSOSessionName is the sso-session the profile uses when NoCredentialProcess is set.
+	// If it is empty, the profile uses a hand-written sso-session for its start URL and region,
+	// or the session generated with MergeOpts.SessionName. The profile name template sees the session
+	// the profile ends up using, rather than MergeOpts.SessionName.
 	SSOSessionName     string
 	AccountID   string
 	RoleName       string
 	GeneratedFrom  string
 	Region         string
 	CommonFateURL  string
+	// OrganizationalUnit is the ID of the organizational unit the account belongs to, if known.
+	// It is used to apply role preferences which are scoped to organizational units.
+	OrganizationalUnit string
+	// SourceID identifies the source which returned the profile, if the source has an ID.
+	// It is recorded in the generated section so that PruneSourceIDs can find it.
+	SourceID string
+	// Extra are additional settings written to the profile, such as output or retry_mode.
+	// They are combined with MergeOpts.ExtraKeys, and take precedence over them.
+	Extra map[string]string
+	// Nested are settings written as nested values, such as the s3 settings:
+	// {"s3": {"max_concurrent_requests": "20"}}.
+	// They are combined with MergeOpts.NestedKeys, and take precedence over them.
+	Nested map[string]map[string]string
 	// Legacy format used for credential process
 	SSOStartURL string
 	SSORegion   string
@@ -62,16 +84,38 @@ type credentialProcessProfile struct {
 	AccountID            string `ini:"granted_sso_account_id"`
 	RoleName                string `ini:"granted_sso_role_name"`
 	CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
 	CredentialProcess       string `ini:"credential_process"`
 	Region                  string `ini:"region,omitempty"`
+	Extra                   map[string]string `ini:"-"`
+	Nested                  map[string]map[string]string `ini:"-"`
+}
+
+func (p *credentialProcessProfile) extraKeys() map[string]string {
+	return p.Extra
+}
+
+func (p *credentialProcessProfile) nestedKeys() map[string]map[string]string {
+	return p.Nested
 }
 
 type regularProfile struct {
 	SSOSession              string `ini:"sso_session"`
 	AccountID            string `ini:"sso_account_id"`
 	CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
 	RoleName                string `ini:"sso_role_name"`
 	Region                  string `ini:"region,omitempty"`
+	Extra                   map[string]string `ini:"-"`
+	Nested                  map[string]map[string]string `ini:"-"`
+}
+
+func (p *regularProfile) extraKeys() map[string]string {
+	return p.Extra
+}
+
+func (p *regularProfile) nestedKeys() map[string]map[string]string {
+	return p.Nested
 }
 
 func (a *AccountProfile) ToIni(profileName string, noCredentialProcess bool) any {
@@ -81,13 +125,13 @@ func (a *AccountProfile) ToIni(profileName string, noCredentialProcess bool) any
 				AccountID:            a.AccountID,
 				RoleName:                a.RoleName,
 				CommonFateGeneratedFrom: a.GeneratedFrom,
+				CommonFateSource:        a.SourceID,
 				Region:                  a.Region,
+				Extra:                   a.Extra,
+				Nested:                  a.Nested,
 		}
 	}
-	credProcess := "granted credential-process --profile " + profileName
-	if a.CommonFateURL != "" {
-		credProcess += " --url " + a.CommonFateURL
-	}
+	credProcess := credentialProcessCommand(a.credentialProcessArgs(profileName)...)
 	
 	return &credentialProcessProfile{
 		SSOStartURL:             a.SSOStartURL,
@@ -96,8 +140,20 @@ func (a *AccountProfile) ToIni(profileName string, noCredentialProcess bool) any
 		RoleName:                a.RoleName,
 		CredentialProcess:       credProcess,
 		CommonFateGeneratedFrom: a.GeneratedFrom,
+		CommonFateSource:        a.SourceID,
 		Region:                  a.Region,
+		Extra:                   a.Extra,
+		Nested:                  a.Nested,
+	}
+}
+
+// credentialProcessArgs returns the arguments of the default granted credential_process command.
+func (a *AccountProfile) credentialProcessArgs(profileName string) []string {
+	args := []string{"granted", "credential-process", "--profile", profileName}
+	if a.CommonFateURL != "" {
+		args = append(args, "--url", a.CommonFateURL)
 	}
+	return args
 }
 
 type MergeOpts struct {
@@ -109,35 +165,235 @@ type MergeOpts struct {
 	// PruneStartURLs is a slice of AWS SSO start URLs which profiles are being generated for.
 	// Existing profiles with these start URLs will be removed if they aren't found in the Profiles field.
 	PruneStartURLs []string
+	// PruneSourceIDs is a slice of source IDs which profiles are being generated for.
+	// Existing profiles recorded as coming from these sources will be removed if they aren't found
+	// in the Profiles field. When it is set, profiles recorded as coming from any other source
+	// are no longer pruned by PruneStartURLs, so sources sharing a start URL don't prune each other's profiles.
+	// Profiles without a start URL, such as RolesAnywhereProfile and WebIdentityProfile, are only pruned this way.
+	PruneSourceIDs []string
+	// Namespace is recorded in every generated section, so that several generators can share a config file.
+	// Merge only updates, overwrites and prunes generated sections in its own namespace,
+	// and treats sections generated in other namespaces as if they were written by hand.
+	// Sections generated without a namespace belong to the default, empty, namespace.
+	Namespace string
+	// SessionName is the name of the sso-session generated for account profiles without an SSOSessionName
+	// when NoCredentialProcess is set. The prefix is added to it, like it is to profile names.
+	// Profiles which set their own SSOSessionName keep it, and aren't moved to this session.
 	SessionName		string
 	SSOScopes			[]string
+	// PreferRoles is a list of role name patterns, in order of preference, used to decide
+	// between profiles which render to the same name. It is equivalent to RolePreferences
+	// ranked in the order the patterns are given.
 	PreferRoles		[]string
 	Verbose 			bool
 	DefaultRegion string
+	// ConflictPolicy controls what happens when a generated profile name matches
+	// a profile section which was written by hand. Defaults to ConflictOverwrite.
+	ConflictPolicy ConflictPolicy
+	// ConflictSuffix is appended to generated profile names when ConflictPolicy is ConflictRename.
+	// Defaults to DefaultConflictSuffix.
+	ConflictSuffix string
+	// Overrides are permanent per-profile exceptions which are applied on top of the generated values.
+	Overrides []ProfileOverride
+	// PinnedProfiles is a list of profile names or AWS account IDs which must not be updated or pruned.
+	// Sections can also be pinned individually with PinProfiles.
+	PinnedProfiles []string
+	// DuplicateResolver decides what happens when several profiles render to the same name
+	// and the role preferences don't pick one of them.
+	// If nil, the last profile is written and a warning is logged.
+	DuplicateResolver DuplicateResolver
+	// RolePreferences rank roles to decide between profiles which render to the same name.
+	RolePreferences []RolePreference
+	// PruneGracePeriod keeps generated sections which are no longer returned by the sources
+	// for a period of time before pruning them. They are marked with a 'common_fate_stale_since'
+	// timestamp in the meantime, which is cleared if the profile is returned again.
+	// If zero, sections are pruned as soon as they are no longer returned.
+	PruneGracePeriod time.Duration
+	// MaxPruneCount is the most generated sections a single merge may prune.
+	// If more would be pruned, Merge returns an error instead. Zero means no limit.
+	MaxPruneCount int
+	// MaxPrunePercent is the largest percentage of the generated sections for the
+	// pruned start URLs which a single merge may prune. Zero means no limit.
+	MaxPrunePercent float64
+	// ForcePrune prunes sections even if MaxPruneCount or MaxPrunePercent are exceeded.
+	ForcePrune bool
+
+	// RecordContentHash records a hash of the generated values in each section,
+	// so that generated values which were edited by hand can be detected.
+	RecordContentHash bool
+	// RecordGenerationTime records when the generated values in each section last changed.
+	RecordGenerationTime bool
+	// CredentialProcessTemplate is a Go template for the credential_process of generated account profiles,
+	// rendered with CredentialProcessData, whose values are quoted as arguments.
+	// If empty, 'granted credential-process --profile <name>' is used.
+	CredentialProcessTemplate string
+	// ExtraKeys are additional settings written to every generated account profile, such as output or retry_mode.
+	// Settings in AccountProfile.Extra take precedence over them.
+	ExtraKeys map[string]string
+	// NestedKeys are nested settings written to every generated account profile, such as the s3 settings.
+	// Settings in AccountProfile.Nested take precedence over them. The config must be loaded with LoadOptions.
+	NestedKeys map[string]map[string]string
+	// LocalStack generates a LocalStack twin of every generated account profile, if it is set.
+	// Twins are pruned along with the profiles they mirror.
+	LocalStack *LocalStack
+	// BaseProfile is the name of a hand-written profile, such as _base for [profile _base], whose keys
+	// are copied into every generated profile which doesn't set them. Generated and credential keys
+	// are not copied, except for region. The keys are copied again on every merge, so changes to the
+	// base profile are applied to the generated profiles.
+	BaseProfile string
+	// BaseProfileRules choose a different base profile for the generated profiles they match.
+	// The first matching rule is used, and BaseProfile is used if none match.
+	BaseProfileRules []BaseProfileRule
+	// VerifyCredentialProcess checks that the program each credential_process runs can be found
+	// on this machine, and returns an error if it can't.
+	VerifyCredentialProcess bool
+
+	// now returns the current time, and is overridden in tests.
+	now func() time.Time
+	// migrations overrides the format migrations, in tests.
+	migrations []migration
+	// explainer records how each profile is handled, when called from Explain.
+	explainer *explainer
 }
 
+// Merge generated profiles into the config.
+// The profiles in opts.Profiles are not modified.
 func Merge(opts MergeOpts) error {
+	_, err := MergeWithReport(opts)
+	return err
+}
+
+// MergeCopy merges generated profiles into a copy of the config, like MergeWithReport,
+// and returns the copy. opts.Config is not modified.
+func MergeCopy(opts MergeOpts) (*ini.File, *MergeReport, error) {
+	cfg, err := cloneConfig(opts.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.Config = cfg
+	report, err := MergeWithReport(opts)
+	if err != nil {
+		return nil, report, err
+	}
+	return cfg, report, nil
+}
+
+// cloneConfig returns a deep copy of the config.
+// A nil config is treated as an empty one.
+func cloneConfig(cfg *ini.File) (*ini.File, error) {
+	if cfg == nil {
+		return ini.Empty(LoadOptions), nil
+	}
+	var b bytes.Buffer
+	_, err := cfg.WriteTo(&b)
+	if err != nil {
+		return nil, err
+	}
+	return ini.LoadSources(LoadOptions, b.Bytes())
+}
+
+// MergeWithReport merges generated profiles into the config, like Merge,
+// and returns a report of the decisions which were made along the way.
+// The report is returned even if the merge fails, describing the decisions made before it did.
+func MergeWithReport(opts MergeOpts) (*MergeReport, error) {
+	report := &MergeReport{}
+
 	if opts.Verbose {
 		clio.SetLevelFromString("debug")
 	}
 	if opts.SectionNameTemplate == "" {
 		opts.SectionNameTemplate = "{{ .AccountName }}/{{ .RoleName }}"
 	}
+	if opts.ConflictPolicy == "" {
+		opts.ConflictPolicy = ConflictOverwrite
+	}
+	if !opts.ConflictPolicy.valid() {
+		return report, fmt.Errorf("invalid conflict policy %q", opts.ConflictPolicy)
+	}
+	if opts.ConflictSuffix == "" {
+		opts.ConflictSuffix = DefaultConflictSuffix
+	}
+	overrides, err := newOverrideSet(opts.Overrides)
+	if err != nil {
+		return report, err
+	}
+	prefs, err := compileRolePreferences(opts.PreferRoles, opts.RolePreferences)
+	if err != nil {
+		return report, err
+	}
+	err = validateStartURLs(opts)
+	if err != nil {
+		return report, err
+	}
+	credProcess, err := newCredentialProcess(opts.CredentialProcessTemplate, opts.VerifyCredentialProcess)
+	if err != nil {
+		return report, err
+	}
+	err = validateExtraKeys(opts.ExtraKeys)
+	if err != nil {
+		return report, err
+	}
+	err = validateNestedKeys(opts.NestedKeys, opts.ExtraKeys)
+	if err != nil {
+		return report, err
+	}
+	if opts.LocalStack != nil {
+		err = opts.LocalStack.withDefaults().validate()
+		if err != nil {
+			return report, err
+		}
+	}
+	bases, err := compileBaseProfiles(opts.BaseProfile, opts.BaseProfileRules)
+	if err != nil {
+		return report, err
+	}
 	
-	// Separate SSOSession and AccountProfile types
+	// Separate SSOSession and AccountProfile types from custom profile types
 	var ssoSessions []SSOSession
 	var accountProfiles []*AccountProfile
+	var customProfiles []Profile
 	
 	for _, profile := range opts.Profiles {
 		switch p := profile.(type) {
 		case *SSOSession:
 			ssoSessions = append(ssoSessions, *p) // Store a copy of the session
 		case *AccountProfile:
-			accountProfiles = append(accountProfiles, p)
+			// work on a copy, as the profile is normalized and updated as it is merged
+			c := *p
+			opts.explainer.start(&c)
+			accountProfiles = append(accountProfiles, &c)
+		case Profile:
+			if p.Kind() == "" {
+				return report, fmt.Errorf("profile type %T has an empty kind", p)
+			}
+			if v, ok := p.(validatedProfile); ok {
+				err = v.validate()
+				if err != nil {
+					return report, err
+				}
+			}
+			customProfiles = append(customProfiles, p)
 		default:
-			return nil // Unsupported profile type, skip
+			return report, fmt.Errorf("unsupported profile type %T: it must implement the Profile interface", p)
 		}
 	}
+
+	needsNestedValues := len(opts.NestedKeys) > 0 || (opts.LocalStack != nil && len(opts.LocalStack.Services) > 0)
+	for _, accountProfile := range accountProfiles {
+		needsNestedValues = needsNestedValues || len(accountProfile.Nested) > 0
+	}
+	err = checkNestedValues(opts.Config, needsNestedValues)
+	if err != nil {
+		return report, err
+	}
+
+	err = migrateSections(opts, report)
+	if err != nil {
+		return report, err
+	}
+	meta := newSectionMetadata(opts)
+	// pending holds the sections to write, which are only written once every profile has been checked
+	pending := newPendingSections(opts.Config, meta)
 		
 
 	// Sort profiles by CombinedName (AccountName/RoleName)
@@ -150,50 +406,47 @@ func Merge(opts MergeOpts) error {
 	funcMap := sprig.TxtFuncMap()
 	sectionNameTempl, err := template.New("").Funcs(funcMap).Parse(opts.SectionNameTemplate)
 	if err != nil {
-		return err
+		return report, err
 	}
 
-	// remove any config sections that have 'common_fate_generated_from' as a key
-	for _, sec := range opts.Config.Sections() {
-		var startURL string
-
-		if sec.HasKey("granted_sso_start_url") {
-			startURL = sec.Key("granted_sso_start_url").String()
-		} else if sec.HasKey("sso_start_url") {
-			startURL = sec.Key("sso_start_url").String()
-		}
+	// written tracks the sections written during this merge, so that they aren't pruned
+	written := make(map[string]bool)
 
-		for _, pruneURL := range opts.PruneStartURLs {
-			isGenerated := sec.HasKey("common_fate_generated_from") // true if the profile was created automatically.
+	// sso-session sections written by hand or generated in another namespace are never deleted or rewritten.
+	// Generated profiles are pointed at them instead when they cover the same start URL and region.
+	manualSessions := existingSSOSessions(opts.Config, opts.Namespace)
+	sessionAliases := make(map[string]string)
 
-			if isGenerated && startURL == pruneURL {
-				opts.Config.DeleteSection(sec.Name())
-			}
-		}
-	}
-	
 	for _, ssoSession := range ssoSessions {
 		ssoSession.SSOSessionName = normalizeAccountName(ssoSession.SSOSessionName)
 
-		sectionName := "sso-session " + ssoSession.SSOSessionName
-		
-		opts.Config.DeleteSection(sectionName)
-		section, err := opts.Config.NewSection(sectionName)
-		if err != nil {
-			return err
+		if existing, ok := manualSessions[newSSOSessionKey(ssoSession.SSOStartURL, ssoSession.SSORegion)]; ok {
+			clio.Debugf("Using existing sso-session %s for %s", existing, ssoSession.SSOStartURL)
+			sessionAliases[ssoSession.SSOSessionName] = existing
+			continue
+		}
+
+		sectionName := ssoSessionSectionPrefix + ssoSession.SSOSessionName
+		if isPinnedSection(opts.Config, sectionName, opts.PinnedProfiles) {
+			clio.Infof("Skipping %s as it is pinned", sectionName)
+			report.addPinned(sectionName)
+			continue
 		}
+		if isManualSection(opts.Config, sectionName, opts.Namespace) {
+			return report, fmt.Errorf("section [%s] already exists and was not generated, refusing to overwrite it", sectionName)
+		}
+		
 		entry := ssoSession.ToIni(ssoSession.SSOSessionName, opts.NoCredentialProcess)
-		err = section.ReflectFrom(entry)
+		err := pending.addEntry(sectionName, entry)
 		if err != nil {
-			return err
+			return report, err
 		}
+		written[sectionName] = true
 	}
 
 	// Create auto-generated SSO session profiles when using no-credential-process mode
 	// and the profile doesn't already reference an existing SSO session
-	var ssoSessionName string
 	if opts.NoCredentialProcess {
-		ssoSessionName = opts.SessionName
 		// Track created session names to avoid duplicates
 		createdSessions := make(map[string]bool)
 		
@@ -203,6 +456,13 @@ func Merge(opts MergeOpts) error {
 			if accountProfile.SSOSessionName != "" {
 				continue
 			}
+
+			// Reuse an existing session for the same SSO instance if there is one
+			if existing, ok := manualSessions[newSSOSessionKey(accountProfile.SSOStartURL, accountProfile.SSORegion)]; ok {
+				opts.explainer.step(accountProfile, "using the existing sso-session %s for %s", existing, accountProfile.SSOStartURL)
+				accountProfile.SSOSessionName = existing
+				continue
+			}
 			
 			// Generate a session name based on account name and role
 			sessionName := opts.SessionName
@@ -212,6 +472,7 @@ func Merge(opts MergeOpts) error {
 			
 			// Skip if we've already created this session
 			if createdSessions[sessionName] {
+				accountProfile.SSOSessionName = sessionName
 				continue
 			}
 			
@@ -222,23 +483,28 @@ func Merge(opts MergeOpts) error {
 				SSOStartURL:    accountProfile.SSOStartURL,
 				SSORegion:      accountProfile.SSORegion,
 				GeneratedFrom:  accountProfile.GeneratedFrom,
+				SourceID:       accountProfile.SourceID,
 			}
 			
 			// Create the session section
-			sectionName := "sso-session " + sessionName
-			opts.Config.DeleteSection(sectionName)
-			section, err := opts.Config.NewSection(sectionName)
-			if err != nil {
-				return err
-			}
-			
-			entry := ssoSession.ToIni(sessionName, opts.NoCredentialProcess)
-			err = section.ReflectFrom(entry)
-			if err != nil {
-				return err
+			sectionName := ssoSessionSectionPrefix + sessionName
+			if isPinnedSection(opts.Config, sectionName, opts.PinnedProfiles) {
+				clio.Infof("Skipping %s as it is pinned", sectionName)
+				report.addPinned(sectionName)
+			} else {
+				if isManualSection(opts.Config, sectionName, opts.Namespace) {
+					return report, fmt.Errorf("section [%s] already exists and was not generated, refusing to overwrite it", sectionName)
+				}
+				entry := ssoSession.ToIni(sessionName, opts.NoCredentialProcess)
+				err := pending.addEntry(sectionName, entry)
+				if err != nil {
+					return report, err
+				}
+				written[sectionName] = true
 			}
 			
 			// Update the account profile to reference this session
+			opts.explainer.step(accountProfile, "using the generated sso-session %s", sessionName)
 			accountProfile.SSOSessionName = sessionName
 			
 			// Mark this session as created
@@ -247,113 +513,202 @@ func Merge(opts MergeOpts) error {
 	}
 	
 	// Now process all account profiles
-	var seenProfileNames []string
-	var profileNameToRoles = make(map[string][]string)
+	var planned []plannedProfile
 	
 	for _, accountProfile := range accountProfiles {
 		clio.Debugf("Processing account profile: %s/%s", accountProfile.AccountName, accountProfile.RoleName)
 		accountProfile.AccountName = normalizeAccountName(accountProfile.AccountName)
-		accountProfile.SSOSessionName = ssoSessionName
+		if alias, ok := sessionAliases[normalizeAccountName(accountProfile.SSOSessionName)]; ok {
+			opts.explainer.step(accountProfile, "using the existing sso-session %s instead of %s", alias, accountProfile.SSOSessionName)
+			accountProfile.SSOSessionName = alias
+		}
+
+		override, hasOverride := overrides.match(accountProfile.AccountID, accountProfile.RoleName)
+		if hasOverride {
+			opts.explainer.step(accountProfile, "matched overrides for %s", override)
+		}
+		if override.AccountName != "" {
+			opts.explainer.step(accountProfile, "override replaced the account name with %s", override.AccountName)
+			accountProfile.AccountName = normalizeAccountName(override.AccountName)
+		}
+
 		sectionNameBuffer := bytes.NewBufferString("")
 		err := sectionNameTempl.Execute(sectionNameBuffer, accountProfile)
 		if err != nil {
-			return err
+			return report, err
 		}
 		
+		if len(accountProfile.Extra) > 0 {
+			err = validateExtraKeys(accountProfile.Extra)
+			if err != nil {
+				return report, fmt.Errorf("profile %s/%s: %w", accountProfile.AccountID, accountProfile.RoleName, err)
+			}
+		}
+		accountProfile.Extra = mergeExtraKeys(opts.ExtraKeys, accountProfile.Extra)
+		if len(accountProfile.Nested) > 0 {
+			err = validateNestedKeys(accountProfile.Nested, accountProfile.Extra)
+			if err != nil {
+				return report, fmt.Errorf("profile %s/%s: %w", accountProfile.AccountID, accountProfile.RoleName, err)
+			}
+		}
+		accountProfile.Nested = mergeNestedKeys(opts.NestedKeys, accountProfile.Nested)
+
 		if accountProfile.Region == "" && opts.DefaultRegion != "" {
+			opts.explainer.step(accountProfile, "using the default region %s", opts.DefaultRegion)
 			accountProfile.Region = opts.DefaultRegion
 		}
 		
 		profileName := opts.Prefix + sectionNameBuffer.String()
-		sectionName := "profile " + profileName
-		
-		// Is profileName in the seenProfileNames list?
-		var isSeen bool
-		for _, seenName := range seenProfileNames {
-			if seenName == profileName {
-				isSeen = true
-				break
-			}
+		if override.ProfileName != "" {
+			opts.explainer.step(accountProfile, "override replaced the profile name with %s", override.ProfileName)
+			profileName = opts.Prefix + override.ProfileName
 		}
-		var isOverwrite = false
-		if isSeen {
-			// If it is, check if the user provided any PreferRoles
-			if len(opts.PreferRoles) > 0 {
-				existingSection, err := opts.Config.GetSection(sectionName)
-				if err != nil {
-					return err
-				}
-				thisRoleName := accountProfile.RoleName
-				// Check granted_sso_role_name and sso_role_name to get the existing role
-				var existingRoleName string
-				if existingSection.HasKey("granted_sso_role_name") {
-					existingRoleName = existingSection.Key("granted_sso_role_name").String()
+		opts.explainer.rendered(accountProfile, sectionNameBuffer.String(), profileName)
+
+		if override.Suppress {
+			sectionName := "profile " + profileName
+			clio.Debugf("Suppressing profile %s as it is suppressed by an override for %s", profileName, override)
+			if sec, err := opts.Config.GetSection(sectionName); err == nil && isOwnedSection(sec, opts.Namespace) {
+				if isPinned(sec, opts.PinnedProfiles) {
+					report.addPinned(sectionName)
 				} else {
-					existingRoleName = existingSection.Key("sso_role_name").String()
+					pending.delete(sectionName)
 				}
-
-				var shouldOverwrite bool = true
-				for _, preferRole := range opts.PreferRoles {
-					r, _ := regexp2.Compile(preferRole, 0)
-					matchesThisRole, _ := r.MatchString(thisRoleName)
-					matchesExistingRole, _ := r.MatchString(existingRoleName)
-					if matchesThisRole && !matchesExistingRole {
-						// Overwrite the existing section with the new profile
-						clio.Debugf("[%s] Overwriting existing role %s with new role %s", profileName, existingRoleName, thisRoleName)
-						break
-					} else if !matchesThisRole && matchesExistingRole {
-						// Don't overwrite the existing section with the new profile
-						clio.Debugf("[%s} Existing role %s matches with a higher priority than %s (%s)", profileName, existingRoleName, thisRoleName, preferRole)
-						shouldOverwrite = false
-						break
-					}
 			}
-			if !shouldOverwrite {
-				clio.Infof("Skipping profile %s as it already exists and no prefer roles matched higher than the existing role", profileName)
-				continue
-			}
-			isOverwrite = true
+			report.Suppressed = append(report.Suppressed, profileName)
+			opts.explainer.skipped(accountProfile, "the profile is suppressed by an override for %s", override)
+			continue
 		}
+
+		planned = append(planned, plannedProfile{
+			profile:     accountProfile,
+			override:    override,
+			hasOverride: hasOverride,
+			name:        profileName,
+		})
 	}
 
-		opts.Config.DeleteSection(sectionName)
-		section, err := opts.Config.NewSection(sectionName)
+	planned, err = resolveDuplicates(planned, prefs, opts.DuplicateResolver, report, opts.explainer)
+	if err != nil {
+		return report, err
+	}
+
+	// generatedNames records the name each account and role was written as,
+	// so that chained profiles can refer to them
+	generatedNames := make(map[profileRef]string)
+	var twins []localStackTwin
+
+	for _, p := range planned {
+		accountProfile := p.profile
+		profileName := p.name
+		sectionName := "profile " + profileName
+		ref := profileRef{AccountID: accountProfile.AccountID, RoleName: accountProfile.RoleName}
+
+		if isPinnedSection(opts.Config, sectionName, opts.PinnedProfiles) {
+			clio.Infof("Skipping profile %s as it is pinned", profileName)
+			report.addPinned(sectionName)
+			opts.explainer.skipped(accountProfile, "the existing section [%s] is pinned", sectionName)
+			generatedNames[ref] = profileName
+			twins = append(twins, localStackTwin{profile: accountProfile, profileName: profileName})
+			continue
+		}
+
+		conflict, err := resolveConflict(opts, profileName, sectionName, accountSectionName, pending, report)
 		if err != nil {
-			return err
+			return report, err
+		}
+		if conflict != nil {
+			switch conflict.Decision {
+			case ConflictSkip:
+				opts.explainer.skipped(accountProfile, "the section [%s] was written by hand and the conflict policy is %s", sectionName, ConflictSkip)
+				continue
+			case ConflictRename:
+				profileName = conflict.RenamedTo
+				sectionName = accountSectionName(profileName)
+				opts.explainer.step(accountProfile, "renamed to %s as the section [%s] was written by hand", profileName, conflict.SectionName)
+				opts.explainer.renamed(accountProfile, profileName)
+			default:
+				opts.explainer.step(accountProfile, "overwriting the section [%s] which was written by hand", sectionName)
+			}
 		}
 
 		entry := accountProfile.ToIni(profileName, opts.NoCredentialProcess)
-		err = section.ReflectFrom(entry)
+		err = credProcess.apply(entry, accountProfile, profileName)
+		var unquotable *unquotableArgError
+		if errors.As(err, &unquotable) {
+			report.addSkipped(profileName, err)
+			opts.explainer.skipped(accountProfile, "%s", err)
+			continue
+		}
 		if err != nil {
-			return err
+			return report, err
 		}
-		if !isOverwrite {
-			seenProfileNames = append(seenProfileNames, profileName)
-			profileNameToRoles[profileName] = append(profileNameToRoles[profileName], accountProfile.RoleName)
+		generated, err := renderSection(sectionName, entry)
+		if err != nil {
+			return report, err
 		}
-	}
-	// Check for duplicate profile names
-	slices.Sort(seenProfileNames)
-	var dupes []string
-	for i := 1; i < len(seenProfileNames); i++ {
-		if seenProfileNames[i] == seenProfileNames[i-1] {
-			dupes = append(dupes, seenProfileNames[i])
+		err = applyBaseProfile(opts, generated, bases.forProfile(accountProfile.AccountID, accountProfile.RoleName, accountProfile.OrganizationalUnit))
+		if err != nil {
+			return report, err
 		}
-	}
-	slices.Sort(dupes)
-	dupes = slices.Compact(dupes)
-	if len(dupes) > 0 {
-		clio.Warn("Duplicate profile names detected. Only the last result will be used. You may need to manually modify the generated config file to use the correct role:")
-		for _, dup := range dupes {
-			roles := profileNameToRoles[dup]
-			clio.Warnf("Profile %s has roles: %s", dup, strings.Join(roles, ", "))
+		if p.hasOverride {
+			err = p.override.apply(generated)
+			if err != nil {
+				return report, err
+			}
 		}
+		pending.add(generated, func(section *ini.Section) {
+			opts.explainer.written(accountProfile, section)
+		})
+		written[sectionName] = true
+		generatedNames[ref] = profileName
+		twins = append(twins, localStackTwin{profile: accountProfile, profileName: profileName})
 	}
 
-	return nil
+	err = mergeCustomProfiles(opts, customProfiles, sectionNameTempl, bases, pending, written, generatedNames, report)
+	if err != nil {
+		return report, err
+	}
+
+	err = mergeLocalStack(opts, twins, bases, pending, written, report)
+	if err != nil {
+		return report, err
+	}
+
+	err = checkSourceProfileCycles(pending)
+	if err != nil {
+		return report, err
+	}
+	err = pending.write()
+	if err != nil {
+		return report, err
+	}
+
+	// remove any config sections that have 'common_fate_generated_from' as a key,
+	// unless they were written during this merge
+	err = prune(opts, written, report)
+	if err != nil {
+		return report, err
+	}
+	pruneServicesSections(opts, written, report)
+
+	report.UnusedOverrides = overrides.unused()
+	for _, o := range report.UnusedOverrides {
+		clio.Warnf("Override for %s didn't match any generated profile", o)
+	}
+
+	return report, nil
 }
 
 
 func normalizeAccountName(accountName string) string {
 	return strings.ReplaceAll(accountName, " ", "-")
 }
+
+// currentTime returns the time the merge is running at.
+func (opts MergeOpts) currentTime() time.Time {
+	if opts.now != nil {
+		return opts.now().UTC()
+	}
+	return time.Now().UTC()
+}
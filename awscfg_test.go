@@ -54,6 +54,7 @@ granted_sso_account_id     = 123456789012
 granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile testing/DevRole --url https://commonfate.example.com
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -93,6 +94,7 @@ sso_start_url              = https://example.com
 sso_registration_scopes    = example-scope
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile testing/DevRole]
 sso_session                = example-session
@@ -100,6 +102,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
 region                     = ap-southeast-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -131,6 +134,7 @@ granted_sso_account_id     = 123456789012
 granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile testing/DevRole
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -164,6 +168,7 @@ granted_sso_account_id     = 123456789012
 granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile testing-title-case-with-space/DevRole --url https://commonfate.example.com
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -209,6 +214,7 @@ granted_sso_role_name      = DevRoleOne
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile account1/DevRoleOne
 region                     = us-west-2
+common_fate_format_version = 1
 
 [profile account1/DevRoleTwo]
 granted_sso_start_url      = https://example.awsapps.com/start
@@ -218,6 +224,7 @@ granted_sso_role_name      = DevRoleTwo
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile account1/DevRoleTwo
 region                     = us-west-2
+common_fate_format_version = 1
 
 [profile account2/DevRoleOne]
 granted_sso_start_url      = https://example.awsapps.com/start
@@ -227,8 +234,150 @@ granted_sso_role_name      = DevRoleOne
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile account2/DevRoleOne
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
+		{
+			name: "keeps user-added keys when regenerating a profile",
+			args: MergeOpts{
+				Config: parseIni(t, `
+[profile testing/DevRole]
+granted_sso_start_url      = https://example.com
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+output                     = json
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile testing/DevRole
+region                     = us-west-2
+cli_pager                  =
+`),
+				Profiles: []SSOProfile{
+					&AccountProfile{
+						SSOStartURL:   "https://example.com",
+						SSORegion:     "us-east-1",
+						AccountID:     "123456789012",
+						AccountName:   "testing",
+						RoleName:      "DevRole",
+						GeneratedFrom: "aws-sso",
+					},
+				},
+				PruneStartURLs: []string{"https://example.com"},
+			},
+			want: `
+[profile testing/DevRole]
+granted_sso_start_url      = https://example.com
+granted_sso_region         = us-east-1
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+output                     = json
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile testing/DevRole
+cli_pager                  = 
+common_fate_format_version = 1
+`,
+		},
+		{
+			name: "reuses existing sso-session for the same start url",
+			args: MergeOpts{
+				Config: parseIni(t, `
+[sso-session corp]
+sso_start_url = https://Example.awsapps.com/start/
+sso_region = ap-southeast-2
+sso_registration_scopes = sso:account:access
+`),
+				NoCredentialProcess: true,
+				Profiles: []SSOProfile{
+					&SSOSession{
+						SSOStartURL:           "https://example.awsapps.com/start",
+						SSOSessionName:        "example-session",
+						SSORegistrationScopes: "example-scope",
+						SSORegion:             "ap-southeast-2",
+						GeneratedFrom:         "aws-sso",
+					},
+					&AccountProfile{
+						SSOSessionName: "example-session",
+						SSOStartURL:    "https://example.awsapps.com/start",
+						AccountID:      "123456789012",
+						AccountName:    "testing",
+						RoleName:       "DevRole",
+						GeneratedFrom:  "aws-sso",
+					},
+				},
+			},
+			want: `
+[sso-session corp]
+sso_start_url           = https://Example.awsapps.com/start/
+sso_region              = ap-southeast-2
+sso_registration_scopes = sso:account:access
+
+[profile testing/DevRole]
+sso_session                = corp
+sso_account_id             = 123456789012
+common_fate_generated_from = aws-sso
+sso_role_name              = DevRole
+common_fate_format_version = 1
+`,
+		},
+		{
+			name: "reuses existing sso-session instead of creating one",
+			args: MergeOpts{
+				Config: parseIni(t, `
+[sso-session corp]
+sso_start_url = https://example.awsapps.com/start
+sso_region = ap-southeast-2
+`),
+				NoCredentialProcess: true,
+				SessionName:         "generated",
+				Profiles: []SSOProfile{
+					&AccountProfile{
+						SSOStartURL:   "https://example.awsapps.com/start",
+						SSORegion:     "ap-southeast-2",
+						AccountID:     "123456789012",
+						AccountName:   "testing",
+						RoleName:      "DevRole",
+						GeneratedFrom: "aws-sso",
+					},
+				},
+			},
+			want: `
+[sso-session corp]
+sso_start_url = https://example.awsapps.com/start
+sso_region    = ap-southeast-2
+
+[profile testing/DevRole]
+sso_session                = corp
+sso_account_id             = 123456789012
+common_fate_generated_from = aws-sso
+sso_role_name              = DevRole
+common_fate_format_version = 1
+`,
+		},
+		{
+			name: "does not overwrite existing sso-session with a different start url",
+			args: MergeOpts{
+				Config: parseIni(t, `
+[sso-session example-session]
+sso_start_url = https://other.awsapps.com/start
+sso_region = ap-southeast-2
+`),
+				NoCredentialProcess: true,
+				Profiles: []SSOProfile{
+					&SSOSession{
+						SSOStartURL:    "https://example.awsapps.com/start",
+						SSOSessionName: "example-session",
+						SSORegion:      "ap-southeast-2",
+						GeneratedFrom:  "aws-sso",
+					},
+				},
+			},
+			want: `
+[sso-session example-session]
+sso_start_url = https://other.awsapps.com/start
+sso_region    = ap-southeast-2
+`,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -250,3 +399,121 @@ region                     = us-west-2
 		})
 	}
 }
+
+func TestMerge_DoesNotModifyProfiles(t *testing.T) {
+	profile := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		SSORegion:     "ap-southeast-2",
+		AccountID:     "123456789012",
+		AccountName:   "my account",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+	want := *profile
+
+	cfg := parseIni(t, "")
+	err := Merge(MergeOpts{
+		Config:              cfg,
+		Profiles:            []SSOProfile{profile},
+		NoCredentialProcess: true,
+		SessionName:         "example",
+		DefaultRegion:       "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, want, *profile)
+	assert.True(t, cfg.HasSection("profile my-account/DevRole"))
+}
+
+func TestMergeCopy(t *testing.T) {
+	cfg := parseIni(t, `
+[profile manual]
+region = us-east-1
+`)
+	got, _, err := MergeCopy(MergeOpts{
+		Config: cfg,
+		Profiles: []SSOProfile{
+			&AccountProfile{SSOStartURL: "https://example.awsapps.com/start", AccountID: "123456789012", AccountName: "prod", RoleName: "DevRole", GeneratedFrom: "aws-sso"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, got.HasSection("profile prod/DevRole"))
+	assert.True(t, got.HasSection("profile manual"))
+	assert.False(t, cfg.HasSection("profile prod/DevRole"))
+}
+
+func TestMerge_SSOSessionName(t *testing.T) {
+	profiles := func() []SSOProfile {
+		return []SSOProfile{
+			&SSOSession{
+				SSOSessionName: "team",
+				SSOStartURL:    "https://team.awsapps.com/start",
+				SSORegion:      "us-east-1",
+				GeneratedFrom:  "aws-sso",
+			},
+			&AccountProfile{
+				SSOStartURL:    "https://team.awsapps.com/start",
+				SSORegion:      "us-east-1",
+				SSOSessionName: "team",
+				AccountID:      "123456789012",
+				AccountName:    "team",
+				RoleName:       "DevRole",
+				GeneratedFrom:  "aws-sso",
+			},
+			&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				SSORegion:     "us-east-1",
+				AccountID:     "123456789013",
+				AccountName:   "prod",
+				RoleName:      "DevRole",
+				GeneratedFrom: "aws-sso",
+			},
+		}
+	}
+
+	cfg := parseIni(t, "")
+	err := Merge(MergeOpts{
+		Config:              cfg,
+		Profiles:            profiles(),
+		NoCredentialProcess: true,
+		SessionName:         "corp",
+		Prefix:              "cf-",
+		SectionNameTemplate: "{{ .SSOSessionName }}/{{ .AccountName }}/{{ .RoleName }}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// profiles with their own session keep it, and the others use the prefixed generated session
+	assertIni(t, cfg, `
+[sso-session team]
+sso_start_url              = https://team.awsapps.com/start
+sso_registration_scopes    = 
+sso_region                 = us-east-1
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+
+[sso-session cf-corp]
+sso_start_url              = https://example.awsapps.com/start
+sso_registration_scopes    = 
+sso_region                 = us-east-1
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+
+[profile cf-cf-corp/prod/DevRole]
+sso_session                = cf-corp
+sso_account_id             = 123456789013
+common_fate_generated_from = aws-sso
+sso_role_name              = DevRole
+common_fate_format_version = 1
+
+[profile cf-team/team/DevRole]
+sso_session                = team
+sso_account_id             = 123456789012
+common_fate_generated_from = aws-sso
+sso_role_name              = DevRole
+common_fate_format_version = 1
+`)
+}
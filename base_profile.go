@@ -0,0 +1,141 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/common-fate/clio"
+	"github.com/dlclark/regexp2"
+	"gopkg.in/ini.v1"
+)
+
+// baseProfileKey records the base profile a generated section inherited keys from.
+const baseProfileKey = "common_fate_base_profile"
+
+// uninheritableKeys are credential settings which are never copied from a base profile,
+// as they would replace the credentials of the generated profile.
+var uninheritableKeys = map[string]bool{
+	"aws_access_key_id":       true,
+	"aws_secret_access_key":   true,
+	"aws_session_token":       true,
+	"credential_source":       true,
+	"role_arn":                true,
+	"source_profile":          true,
+	"web_identity_token_file": true,
+}
+
+// BaseProfileRule chooses the base profile for the generated profiles it matches.
+// A rule with no account IDs, organizational units or role pattern matches every profile.
+type BaseProfileRule struct {
+	// BaseProfile is the name of the profile to inherit keys from, such as _base-prod for [profile _base-prod].
+	BaseProfile string
+	// RolePattern is a regular expression matched against the role name. If empty, every role matches.
+	RolePattern string
+	// AccountIDs restricts the rule to the given AWS accounts.
+	AccountIDs []string
+	// OrganizationalUnits restricts the rule to accounts in the given organizational units.
+	OrganizationalUnits []string
+}
+
+type compiledBaseProfileRule struct {
+	BaseProfileRule
+	regex *regexp2.Regexp
+}
+
+// baseProfiles are the compiled base profile rules of a merge.
+type baseProfiles struct {
+	defaultName string
+	rules       []compiledBaseProfileRule
+}
+
+// compileBaseProfiles validates and compiles the base profile rules.
+func compileBaseProfiles(defaultName string, rules []BaseProfileRule) (*baseProfiles, error) {
+	b := &baseProfiles{defaultName: defaultName}
+	if strings.ContainsAny(defaultName, profileSectionIllegalChars) {
+		return nil, fmt.Errorf("base profile %q must not contain any of these illegal characters (%s)", defaultName, profileSectionIllegalChars)
+	}
+	for _, r := range rules {
+		if r.BaseProfile == "" {
+			return nil, fmt.Errorf("base profile rule has no base profile")
+		}
+		if strings.ContainsAny(r.BaseProfile, profileSectionIllegalChars) {
+			return nil, fmt.Errorf("base profile %q must not contain any of these illegal characters (%s)", r.BaseProfile, profileSectionIllegalChars)
+		}
+		compiled := compiledBaseProfileRule{BaseProfileRule: r}
+		if r.RolePattern != "" {
+			regex, err := regexp2.Compile(r.RolePattern, 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base profile role pattern %q: %w", r.RolePattern, err)
+			}
+			compiled.regex = regex
+		}
+		b.rules = append(b.rules, compiled)
+	}
+	return b, nil
+}
+
+// forProfile returns the name of the base profile for a generated profile, or an empty string if it has none.
+// The first matching rule is used, falling back to the default base profile.
+func (b *baseProfiles) forProfile(accountID string, roleName string, ou string) string {
+	for _, r := range b.rules {
+		if len(r.AccountIDs) > 0 && !slices.Contains(r.AccountIDs, accountID) {
+			continue
+		}
+		if len(r.OrganizationalUnits) > 0 && (ou == "" || !slices.Contains(r.OrganizationalUnits, ou)) {
+			continue
+		}
+		if r.regex != nil {
+			ok, err := r.regex.MatchString(roleName)
+			if err != nil {
+				clio.Debugf("Error matching role %s against %s: %s", roleName, r.RolePattern, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+		return r.BaseProfile
+	}
+	return b.defaultName
+}
+
+// inheritableKey returns true if a key in a base profile is copied to the profiles generated from it.
+// Generated keys are not inherited, except for region, which is used if the profile has none.
+func inheritableKey(name string) bool {
+	if strings.HasPrefix(name, "common_fate_") || uninheritableKeys[name] {
+		return false
+	}
+	return name == "region" || !builtinGeneratedKeys[name]
+}
+
+// applyBaseProfile copies the keys of the named base profile which the rendered section doesn't set,
+// and records the base profile in the section. The keys are copied on every merge,
+// so changes to the base profile are applied to the profiles generated from it.
+func applyBaseProfile(opts MergeOpts, generated *ini.Section, baseName string) error {
+	if baseName == "" {
+		return nil
+	}
+	sectionName := accountSectionName(baseName)
+	if baseName == "default" {
+		sectionName = baseName
+	}
+	base, err := opts.Config.GetSection(sectionName)
+	if err != nil {
+		return fmt.Errorf("base profile %s for [%s] doesn't exist", baseName, generated.Name())
+	}
+	if isGeneratedSection(base) {
+		return fmt.Errorf("base profile %s for [%s] must be written by hand, not generated", baseName, generated.Name())
+	}
+	for _, k := range base.Keys() {
+		if !inheritableKey(k.Name()) || generated.HasKey(k.Name()) {
+			continue
+		}
+		err = copyKey(generated, k)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = generated.NewKey(baseProfileKey, baseName)
+	return err
+}
@@ -0,0 +1,225 @@
+package awsconfigfile
+
+import (
+	"testing"
+)
+
+func TestMerge_BaseProfile(t *testing.T) {
+	prod := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+		Region:        "eu-west-1",
+	}
+	dev := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "210987654321",
+		AccountName:   "dev",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+	base := `
+[profile _base]
+output             = json
+region             = us-east-1
+retry_mode         = standard
+aws_access_key_id  = AKIAEXAMPLE
+credential_process = other-tool
+s3                 = 
+  max_concurrent_requests = 20
+`
+
+	tests := []struct {
+		name     string
+		config   string
+		profiles []SSOProfile
+		base     string
+		rules    []BaseProfileRule
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "keys are copied from the base profile",
+			config:   base,
+			profiles: []SSOProfile{prod, dev},
+			base:     "_base",
+			want: base + `
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+output                     = json
+region                     = us-east-1
+retry_mode                 = standard
+s3                         = 
+  max_concurrent_requests = 20
+common_fate_base_profile   = _base
+common_fate_format_version = 1
+common_fate_generated_keys = output,retry_mode,s3
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+output                     = json
+retry_mode                 = standard
+s3                         = 
+  max_concurrent_requests = 20
+common_fate_base_profile   = _base
+common_fate_format_version = 1
+common_fate_generated_keys = output,retry_mode,s3
+`,
+		},
+		{
+			name: "changes to the base profile are applied",
+			config: `
+[profile _base]
+output = text
+
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+output                     = json
+region                     = us-east-1
+retry_mode                 = standard
+cli_pager                  = 
+common_fate_base_profile   = _base
+common_fate_format_version = 1
+common_fate_generated_keys = output,retry_mode
+`,
+			profiles: []SSOProfile{dev},
+			base:     "_base",
+			want: `
+[profile _base]
+output = text
+
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+output                     = text
+cli_pager                  = 
+common_fate_base_profile   = _base
+common_fate_format_version = 1
+common_fate_generated_keys = output
+`,
+		},
+		{
+			name: "rules choose the base profile",
+			config: `
+[profile _base]
+output = json
+
+[profile _base-prod]
+cli_pager = less
+`,
+			profiles: []SSOProfile{prod, dev},
+			base:     "_base",
+			rules:    []BaseProfileRule{{BaseProfile: "_base-prod", AccountIDs: []string{"123456789012"}, RolePattern: "^Dev"}},
+			want: `
+[profile _base]
+output = json
+
+[profile _base-prod]
+cli_pager = less
+
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+output                     = json
+common_fate_base_profile   = _base
+common_fate_format_version = 1
+common_fate_generated_keys = output
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+cli_pager                  = less
+common_fate_base_profile   = _base-prod
+common_fate_format_version = 1
+common_fate_generated_keys = cli_pager
+`,
+		},
+		{
+			name: "profiles without a matching rule or default have no base",
+			config: `
+[profile _base-prod]
+cli_pager = less
+`,
+			profiles: []SSOProfile{dev},
+			rules:    []BaseProfileRule{{BaseProfile: "_base-prod", AccountIDs: []string{"123456789012"}}},
+			want: `
+[profile _base-prod]
+cli_pager = less
+
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:     "missing base profile",
+			profiles: []SSOProfile{dev},
+			base:     "_base",
+			wantErr:  true,
+		},
+		{
+			name: "generated base profile",
+			config: `
+[profile _base]
+common_fate_generated_from = aws-sso
+`,
+			profiles: []SSOProfile{dev},
+			base:     "_base",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid rule pattern",
+			config:   base,
+			profiles: []SSOProfile{dev},
+			rules:    []BaseProfileRule{{BaseProfile: "_base", RolePattern: "("}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseNestedIni(t, tt.config)
+			err := Merge(MergeOpts{
+				Config:           cfg,
+				Profiles:         tt.profiles,
+				BaseProfile:      tt.base,
+				BaseProfileRules: tt.rules,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Command awsconfigfile manages profiles generated in ~/.aws/config.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/makeshift/awsconfigfile"
+	"gopkg.in/ini.v1"
+)
+
+const usage = `Usage: awsconfigfile <command> [flags] [args]
+
+Commands:
+  pin <profile or account ID>...    prevent generated profiles from being updated or pruned
+  unpin <profile or account ID>...  allow pinned profiles to be managed again
+  explain <account ID or role>      show how profiles from a JSON file would be named and merged
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "pin":
+		err = pin(os.Args[2:], true)
+	case "unpin":
+		err = pin(os.Args[2:], false)
+	case "explain":
+		err = explain(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func pin(args []string, pinned bool) error {
+	name, verb := "pin", "Pinned"
+	if !pinned {
+		name, verb = "unpin", "Unpinned"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configFile := fs.String("config", awsconfigfile.DefaultSharedConfigFilename(), "the AWS config file to update")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%s requires at least one profile name or account ID", name)
+	}
+
+	cfg, err := ini.LoadSources(awsconfigfile.LoadOptions, *configFile)
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	if pinned {
+		changed, err = awsconfigfile.PinProfiles(cfg, fs.Args()...)
+	} else {
+		changed, err = awsconfigfile.UnpinProfiles(cfg, fs.Args()...)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = cfg.SaveTo(*configFile)
+	if err != nil {
+		return err
+	}
+	for _, sectionName := range changed {
+		fmt.Printf("%s [%s]\n", verb, sectionName)
+	}
+	return nil
+}
+
+// stringSlice is a flag which can be provided several times.
+type stringSlice []string
+
+func (s *stringSlice) String() string { return strings.Join(*s, ",") }
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func explain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	configFile := fs.String("config", awsconfigfile.DefaultSharedConfigFilename(), "the AWS config file to merge the profiles into")
+	profilesFile := fs.String("profiles", "", "a JSON file containing an array of account profiles, using the AccountProfile field names")
+	overridesFile := fs.String("overrides", "", "a JSON file containing profile overrides")
+	template := fs.String("template", awsconfigfile.DefaultProfileNameTemplate, "the profile name template")
+	prefix := fs.String("prefix", "", "the profile name prefix")
+	sessionName := fs.String("session-name", "", "the name of the generated sso-session")
+	namespace := fs.String("namespace", "", "the namespace the profiles are generated in")
+	defaultRegion := fs.String("default-region", "", "the region to use for profiles which don't have one")
+	noCredentialProcess := fs.Bool("no-credential-process", false, "generate native sso-session profiles instead of using credential_process")
+	var preferRoles stringSlice
+	fs.Var(&preferRoles, "prefer-role", "a role name pattern to prefer when profiles render to the same name (can be repeated)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("explain requires a single account ID or role name")
+	}
+	if *profilesFile == "" {
+		return fmt.Errorf("explain requires -profiles")
+	}
+
+	data, err := os.ReadFile(*profilesFile)
+	if err != nil {
+		return err
+	}
+	var accountProfiles []*awsconfigfile.AccountProfile
+	err = json.Unmarshal(data, &accountProfiles)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", *profilesFile, err)
+	}
+	profiles := make([]awsconfigfile.SSOProfile, len(accountProfiles))
+	for i, p := range accountProfiles {
+		profiles[i] = p
+	}
+
+	var overrides []awsconfigfile.ProfileOverride
+	if *overridesFile != "" {
+		o, err := awsconfigfile.LoadOverridesFile(*overridesFile)
+		if err != nil {
+			return err
+		}
+		overrides = o.Profiles
+	}
+
+	loadOpts := awsconfigfile.LoadOptions
+	loadOpts.Loose = true
+	cfg, err := ini.LoadSources(loadOpts, *configFile)
+	if err != nil {
+		return err
+	}
+
+	explanations, err := awsconfigfile.Explain(awsconfigfile.MergeOpts{
+		Config:              cfg,
+		Profiles:            profiles,
+		SectionNameTemplate: *template,
+		Prefix:              *prefix,
+		SessionName:         *sessionName,
+		Namespace:           *namespace,
+		DefaultRegion:       *defaultRegion,
+		NoCredentialProcess: *noCredentialProcess,
+		PreferRoles:         preferRoles,
+		Overrides:           overrides,
+	}, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(explanations) == 0 {
+		return fmt.Errorf("no profiles in %s match %s", *profilesFile, fs.Arg(0))
+	}
+	for _, e := range explanations {
+		fmt.Println(e)
+	}
+	return nil
+}
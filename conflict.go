@@ -0,0 +1,109 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/common-fate/clio"
+	"gopkg.in/ini.v1"
+)
+
+// ConflictPolicy controls what Merge does when a generated profile name
+// matches a profile section which was written by hand.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces the hand-written section with the generated profile.
+	// This is the default policy.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip leaves the hand-written section alone and doesn't write the generated profile.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename writes the generated profile under a new name, made by
+	// appending a suffix to the rendered profile name.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictFail causes Merge to return an error.
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// DefaultConflictSuffix is appended to generated profile names
+// when the ConflictRename policy is used and no suffix is provided.
+const DefaultConflictSuffix = "-generated"
+
+// ParseConflictPolicy parses a conflict policy from a string, such as a CLI flag.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	p := ConflictPolicy(s)
+	if !p.valid() {
+		return "", fmt.Errorf("invalid conflict policy %q: must be one of %s, %s, %s or %s", s, ConflictOverwrite, ConflictSkip, ConflictRename, ConflictFail)
+	}
+	return p, nil
+}
+
+func (p ConflictPolicy) valid() bool {
+	switch p {
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictFail:
+		return true
+	}
+	return false
+}
+
+// Conflict records a generated profile whose name matched a hand-written section,
+// and what Merge did about it.
+type Conflict struct {
+	// ProfileName is the rendered name of the generated profile.
+	ProfileName string
+	// SectionName is the name of the existing hand-written section.
+	SectionName string
+	// Decision is the policy which was applied to the conflict.
+	Decision ConflictPolicy
+	// RenamedTo is the name the generated profile was written under
+	// when the conflict was resolved by renaming it.
+	RenamedTo string
+}
+
+// renameConflictingProfile returns a profile name made from the rendered name and suffix
+// that doesn't clash with any section in the config which isn't owned by the namespace.
+func renameConflictingProfile(cfg *ini.File, profileName string, suffix string, namespace string, sectionName func(profileName string) string) string {
+	candidate := profileName + suffix
+	for i := 2; isManualSection(cfg, sectionName(candidate), namespace); i++ {
+		candidate = profileName + suffix + strconv.Itoa(i)
+	}
+	return candidate
+}
+
+// resolveConflict applies the conflict policy if the section a profile is due to be written to
+// exists and is not owned by Merge. It returns nil if there is no conflict.
+//
+// When the decision is ConflictRename, the profile should be written as RenamedTo instead.
+// When it is ConflictSkip, the profile shouldn't be written at all.
+// When it is ConflictOverwrite, the existing section is deleted along with the other pending changes.
+//
+// Sections generated in another namespace belong to another generator and are never overwritten:
+// the profile is skipped instead when the policy is ConflictOverwrite.
+func resolveConflict(opts MergeOpts, profileName string, section string, sectionName func(profileName string) string, pending *pendingSections, report *MergeReport) (*Conflict, error) {
+	if !isManualSection(opts.Config, section, opts.Namespace) {
+		return nil, nil
+	}
+
+	conflict := Conflict{ProfileName: profileName, SectionName: section, Decision: opts.ConflictPolicy}
+	if opts.ConflictPolicy == ConflictOverwrite && isGeneratedSection(opts.Config.Section(section)) {
+		clio.Warnf("Skipping profile %s as [%s] was generated in the %q namespace", profileName, section, keyValue(opts.Config.Section(section), namespaceKey))
+		conflict.Decision = ConflictSkip
+		report.Conflicts = append(report.Conflicts, conflict)
+		return &conflict, nil
+	}
+	switch opts.ConflictPolicy {
+	case ConflictSkip:
+		clio.Warnf("Skipping profile %s as a section with the same name already exists and was not generated", profileName)
+	case ConflictFail:
+		report.Conflicts = append(report.Conflicts, conflict)
+		return nil, fmt.Errorf("generated profile %s conflicts with an existing section which was not generated", profileName)
+	case ConflictRename:
+		conflict.RenamedTo = renameConflictingProfile(opts.Config, profileName, opts.ConflictSuffix, opts.Namespace, sectionName)
+		clio.Warnf("Renaming generated profile %s to %s as a section with the same name already exists and was not generated", profileName, conflict.RenamedTo)
+	default:
+		clio.Debugf("Overwriting existing section %s with generated profile", section)
+		pending.delete(section)
+	}
+	report.Conflicts = append(report.Conflicts, conflict)
+	return &conflict, nil
+}
@@ -0,0 +1,124 @@
+package awsconfigfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_ConflictPolicy(t *testing.T) {
+	config := `
+[profile prod/DevRole]
+region = us-east-1
+`
+	profile := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		SSORegion:     "ap-southeast-2",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+
+	tests := []struct {
+		name          string
+		policy        ConflictPolicy
+		suffix        string
+		want          string
+		wantConflicts []Conflict
+		wantErr       bool
+	}{
+		{
+			name: "overwrite by default",
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
+`,
+			wantConflicts: []Conflict{
+				{ProfileName: "prod/DevRole", SectionName: "profile prod/DevRole", Decision: ConflictOverwrite},
+			},
+		},
+		{
+			name:   "skip",
+			policy: ConflictSkip,
+			want: `
+[profile prod/DevRole]
+region = us-east-1
+`,
+			wantConflicts: []Conflict{
+				{ProfileName: "prod/DevRole", SectionName: "profile prod/DevRole", Decision: ConflictSkip},
+			},
+		},
+		{
+			name:   "rename",
+			policy: ConflictRename,
+			suffix: "-sso",
+			want: `
+[profile prod/DevRole]
+region = us-east-1
+
+[profile prod/DevRole-sso]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole-sso
+common_fate_format_version = 1
+`,
+			wantConflicts: []Conflict{
+				{ProfileName: "prod/DevRole", SectionName: "profile prod/DevRole", Decision: ConflictRename, RenamedTo: "prod/DevRole-sso"},
+			},
+		},
+		{
+			name:   "fail",
+			policy: ConflictFail,
+			want: `
+[profile prod/DevRole]
+region = us-east-1
+`,
+			wantConflicts: []Conflict{
+				{ProfileName: "prod/DevRole", SectionName: "profile prod/DevRole", Decision: ConflictFail},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid policy",
+			policy:  "something",
+			want:    config,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, config)
+			report, err := MergeWithReport(MergeOpts{
+				Config:         cfg,
+				Profiles:       []SSOProfile{profile},
+				ConflictPolicy: tt.policy,
+				ConflictSuffix: tt.suffix,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MergeWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if report != nil {
+				assert.Equal(t, tt.wantConflicts, report.Conflicts)
+			}
+
+			var b bytes.Buffer
+			_, err = cfg.WriteTo(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, strings.TrimSpace(tt.want), strings.TrimSpace(b.String()))
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package awsconfigfile
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// CredentialProcessData is the data the credential process template is rendered with.
+// It has the same values as the profile name template, plus the rendered profile name.
+//
+// Each value is quoted for the AWS SDK's credential_process parsing, so that a profile name
+// rendered to prod/DevRole$(id) is passed to the command as it is instead of being run.
+// Values which can't be quoted skip the profile when the template uses them.
+type CredentialProcessData struct {
+	profile     AccountProfile
+	profileName string
+}
+
+// arg returns the value quoted as a credential process argument. Empty values are returned
+// unquoted, so that templates can test for them.
+func (d CredentialProcessData) arg(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	return quoteArg(v)
+}
+
+// ProfileName is the name of the profile being written, including the prefix.
+func (d CredentialProcessData) ProfileName() (string, error) {
+	return d.arg(d.profileName)
+}
+
+func (d CredentialProcessData) AccountName() (string, error) {
+	return d.arg(d.profile.AccountName)
+}
+
+func (d CredentialProcessData) AccountID() (string, error) {
+	return d.arg(d.profile.AccountID)
+}
+
+func (d CredentialProcessData) RoleName() (string, error) {
+	return d.arg(d.profile.RoleName)
+}
+
+func (d CredentialProcessData) SSOSessionName() (string, error) {
+	return d.arg(d.profile.SSOSessionName)
+}
+
+func (d CredentialProcessData) SSOStartURL() (string, error) {
+	return d.arg(d.profile.SSOStartURL)
+}
+
+func (d CredentialProcessData) SSORegion() (string, error) {
+	return d.arg(d.profile.SSORegion)
+}
+
+func (d CredentialProcessData) Region() (string, error) {
+	return d.arg(d.profile.Region)
+}
+
+func (d CredentialProcessData) GeneratedFrom() (string, error) {
+	return d.arg(d.profile.GeneratedFrom)
+}
+
+func (d CredentialProcessData) CommonFateURL() (string, error) {
+	return d.arg(d.profile.CommonFateURL)
+}
+
+func (d CredentialProcessData) OrganizationalUnit() (string, error) {
+	return d.arg(d.profile.OrganizationalUnit)
+}
+
+func (d CredentialProcessData) SourceID() (string, error) {
+	return d.arg(d.profile.SourceID)
+}
+
+// credentialProcess renders credential_process commands for generated account profiles.
+type credentialProcess struct {
+	templ *template.Template
+	// verify checks that the command in each rendered credential_process can be found.
+	verify   bool
+	verified map[string]error
+}
+
+// newCredentialProcess parses the credential process template.
+// If the template is empty, the default granted command is used.
+func newCredentialProcess(text string, verify bool) (*credentialProcess, error) {
+	c := &credentialProcess{verify: verify, verified: make(map[string]error)}
+	if text == "" {
+		return c, nil
+	}
+	funcMap := sprig.TxtFuncMap()
+	funcMap["quotearg"] = quoteArg
+	templ, err := template.New("credential_process").Funcs(funcMap).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential process template: %w", err)
+	}
+	c.templ = templ
+	return c, nil
+}
+
+// ValidateCredentialProcessTemplate returns an error if the credential process template can't be parsed.
+func ValidateCredentialProcessTemplate(text string) error {
+	_, err := newCredentialProcess(text, false)
+	return err
+}
+
+// quoteArg is available to credential process templates as 'quotearg', such as {{ env "TEAM" | quotearg }},
+// to quote values other than those in CredentialProcessData, which are quoted already.
+func quoteArg(arg string) (string, error) {
+	return quoteCredentialProcessArg(arg, credentialProcessStyle)
+}
+
+// apply renders the credential_process for the profile into the generated entry,
+// if the entry uses one. An unquotableArgError is returned if one of its arguments can't be quoted.
+func (c *credentialProcess) apply(entry any, profile *AccountProfile, profileName string) error {
+	p, ok := entry.(*credentialProcessProfile)
+	if !ok {
+		return nil
+	}
+	if c.templ == nil {
+		err := checkCredentialProcessArgs(profile.credentialProcessArgs(profileName)...)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profileName, err)
+		}
+	} else {
+		var b bytes.Buffer
+		err := c.templ.Execute(&b, CredentialProcessData{profile: *profile, profileName: profileName})
+		if err != nil {
+			return fmt.Errorf("rendering the credential process for %s: %w", profileName, err)
+		}
+		p.CredentialProcess = strings.TrimSpace(b.String())
+		if p.CredentialProcess == "" {
+			return fmt.Errorf("the credential process for %s rendered to an empty command", profileName)
+		}
+	}
+	if c.verify {
+		return c.verifyCommand(p.CredentialProcess)
+	}
+	return nil
+}
+
+// verifyCommand returns an error if the program the command runs can't be found.
+func (c *credentialProcess) verifyCommand(command string) error {
+	args, err := splitCommand(command)
+	if err != nil {
+		return fmt.Errorf("credential process %q: %w", command, err)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("credential process is empty")
+	}
+	program := args[0]
+	if err, ok := c.verified[program]; ok {
+		return err
+	}
+	_, err = exec.LookPath(program)
+	if err != nil {
+		err = fmt.Errorf("credential process command %s was not found: %w", program, err)
+	}
+	c.verified[program] = err
+	return err
+}
@@ -0,0 +1,113 @@
+package awsconfigfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_CredentialProcessTemplate(t *testing.T) {
+	profile := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+		CommonFateURL: "https://commonfate.example.com",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		verify   bool
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "default",
+			want: "granted credential-process --profile prod/DevRole --url https://commonfate.example.com",
+		},
+		{
+			name:     "absolute path and extra flags",
+			template: "/opt/granted/bin/granted credential-process --auto-login --profile {{ .ProfileName }}",
+			want:     "/opt/granted/bin/granted credential-process --auto-login --profile prod/DevRole",
+		},
+		{
+			name:     "other helper",
+			template: "aws-sso-util credential-process --account-id {{ .AccountID }} --role-name {{ .RoleName | lower }}",
+			want:     "aws-sso-util credential-process --account-id 123456789012 --role-name devrole",
+		},
+		{
+			name:     "invalid template",
+			template: "{{ .ProfileName ",
+			wantErr:  true,
+		},
+		{
+			name:     "empty command",
+			template: "{{ if false }}granted{{ end }}",
+			wantErr:  true,
+		},
+		{
+			name:     "verified command exists",
+			template: "sh -c 'granted credential-process --profile {{ .ProfileName }}'",
+			verify:   true,
+			want:     "sh -c 'granted credential-process --profile prod/DevRole'",
+		},
+		{
+			name:     "verified command is missing",
+			template: "definitely-not-a-real-credential-helper --profile {{ .ProfileName }}",
+			verify:   true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, "")
+			err := Merge(MergeOpts{
+				Config:                    cfg,
+				Profiles:                  []SSOProfile{profile},
+				CredentialProcessTemplate: tt.template,
+				VerifyCredentialProcess:   tt.verify,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, tt.want, cfg.Section("profile prod/DevRole").Key("credential_process").String())
+		})
+	}
+}
+
+func TestMerge_VerifyQuotedCredentialProcess(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "my tools")
+	err := os.Mkdir(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper := filepath.Join(dir, "granted")
+	err = os.WriteFile(helper, []byte("#!/bin/sh\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := parseIni(t, "")
+	err = Merge(MergeOpts{
+		Config: cfg,
+		Profiles: []SSOProfile{&AccountProfile{
+			SSOStartURL:   "https://example.awsapps.com/start",
+			AccountID:     "123456789012",
+			AccountName:   "prod",
+			RoleName:      "DevRole",
+			GeneratedFrom: "aws-sso",
+		}},
+		CredentialProcessTemplate: "'" + helper + "' credential-process --profile {{ .ProfileName }}",
+		VerifyCredentialProcess:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
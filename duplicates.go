@@ -0,0 +1,206 @@
+package awsconfigfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/common-fate/clio"
+)
+
+// DuplicateResolver decides what happens when several profiles render to the same profile name.
+//
+// ResolveDuplicates is called with the rendered name and the clashing profiles, in the order
+// they were generated. It returns the name to write each profile as, in the same order.
+// Returning an empty name for a profile means it won't be written.
+type DuplicateResolver interface {
+	ResolveDuplicates(name string, profiles []*AccountProfile) ([]string, error)
+}
+
+// DuplicateResolverFunc allows an ordinary function to be used as a DuplicateResolver.
+type DuplicateResolverFunc func(name string, profiles []*AccountProfile) ([]string, error)
+
+func (f DuplicateResolverFunc) ResolveDuplicates(name string, profiles []*AccountProfile) ([]string, error) {
+	return f(name, profiles)
+}
+
+var (
+	// DuplicateError returns an error if any profiles render to the same name.
+	DuplicateError DuplicateResolver = DuplicateResolverFunc(func(name string, profiles []*AccountProfile) ([]string, error) {
+		return nil, fmt.Errorf("profiles %s all render to the same name %s", strings.Join(profileIDs(profiles), ", "), name)
+	})
+
+	// DuplicateAppendAccountID appends the AWS account ID to the name of each clashing profile.
+	DuplicateAppendAccountID DuplicateResolver = appendToDuplicates(func(p *AccountProfile) string {
+		return p.AccountID
+	})
+
+	// DuplicateAppendRoleName appends the role name to the name of each clashing profile.
+	DuplicateAppendRoleName DuplicateResolver = appendToDuplicates(func(p *AccountProfile) string {
+		return p.RoleName
+	})
+
+	// DuplicateAppendHash appends a short hash of the account ID and role name to the name of each clashing profile.
+	// The hash is stable, so a profile keeps the same name between runs.
+	DuplicateAppendHash DuplicateResolver = appendToDuplicates(func(p *AccountProfile) string {
+		sum := sha256.Sum256([]byte(p.AccountID + "/" + p.RoleName))
+		return hex.EncodeToString(sum[:])[:8]
+	})
+
+	// DuplicateKeepFirst writes the first of the clashing profiles and drops the others.
+	DuplicateKeepFirst DuplicateResolver = DuplicateResolverFunc(func(name string, profiles []*AccountProfile) ([]string, error) {
+		names := make([]string, len(profiles))
+		names[0] = name
+		return names, nil
+	})
+)
+
+// appendToDuplicates returns a resolver which appends a suffix derived from each profile to its name.
+func appendToDuplicates(suffix func(p *AccountProfile) string) DuplicateResolver {
+	return DuplicateResolverFunc(func(name string, profiles []*AccountProfile) ([]string, error) {
+		names := make([]string, len(profiles))
+		for i, p := range profiles {
+			names[i] = name + "-" + suffix(p)
+		}
+		return names, nil
+	})
+}
+
+// keepLastDuplicate is used when no DuplicateResolver is provided.
+// It keeps the last of the clashing profiles, which matches the original behaviour of Merge.
+func keepLastDuplicate(name string, profiles []*AccountProfile) []string {
+	clio.Warnf("Duplicate profile name %s detected. Only the last result will be used. You may need to manually modify the generated config file to use the correct role:", name)
+	clio.Warnf("Profile %s has roles: %s", name, strings.Join(profileRoles(profiles), ", "))
+	names := make([]string, len(profiles))
+	names[len(names)-1] = name
+	return names
+}
+
+// DuplicateResolution records how a set of profiles which rendered to the same name were resolved.
+type DuplicateResolution struct {
+	// ProfileName is the name the profiles rendered to.
+	ProfileName string
+	// Profiles identifies each of the clashing profiles as <account ID>/<role name>.
+	Profiles []string
+	// ResolvedNames is the name each profile was written as, in the same order as Profiles.
+	// An empty name means the profile was dropped.
+	ResolvedNames []string
+}
+
+// plannedProfile is an account profile which is due to be written during a merge.
+type plannedProfile struct {
+	profile     *AccountProfile
+	override    ProfileOverride
+	hasOverride bool
+	// name is the rendered profile name, including the prefix.
+	name string
+}
+
+// resolveDuplicates finds planned profiles which share a name and decides which of them are written, and as what.
+// Profiles whose roles are preferred win over the others, and any remaining clashes are passed to the resolver.
+func resolveDuplicates(planned []plannedProfile, prefs *rolePreferences, resolver DuplicateResolver, report *MergeReport, e *explainer) ([]plannedProfile, error) {
+	var order []string
+	groups := make(map[string][]plannedProfile)
+	for _, p := range planned {
+		if _, ok := groups[p.name]; !ok {
+			order = append(order, p.name)
+		}
+		groups[p.name] = append(groups[p.name], p)
+	}
+
+	var resolved []plannedProfile
+	for _, name := range order {
+		group := groups[name]
+		if len(group) == 1 {
+			resolved = append(resolved, group[0])
+			continue
+		}
+
+		profiles := make([]*AccountProfile, len(group))
+		for i, p := range group {
+			profiles[i] = p.profile
+		}
+
+		names := make([]string, len(group))
+		candidates, decision := prefs.choose(name, profiles)
+		if decision != nil {
+			report.RoleDecisions = append(report.RoleDecisions, *decision)
+			for _, p := range profiles {
+				e.step(p, "role preferences: %s", decision.Reason)
+			}
+		}
+		if len(candidates) == 1 {
+			names[candidates[0]] = name
+		} else {
+			remaining := make([]*AccountProfile, len(candidates))
+			for i, idx := range candidates {
+				remaining[i] = profiles[idx]
+			}
+
+			var got []string
+			if resolver == nil {
+				got = keepLastDuplicate(name, remaining)
+			} else {
+				var err error
+				got, err = resolver.ResolveDuplicates(name, remaining)
+				if err != nil {
+					return nil, err
+				}
+				if len(got) != len(remaining) {
+					return nil, fmt.Errorf("duplicate resolver returned %d names for %d profiles named %s", len(got), len(remaining), name)
+				}
+			}
+			for i, idx := range candidates {
+				names[idx] = got[i]
+			}
+		}
+
+		report.Duplicates = append(report.Duplicates, DuplicateResolution{
+			ProfileName:   name,
+			Profiles:      profileIDs(profiles),
+			ResolvedNames: names,
+		})
+
+		for i, p := range group {
+			if names[i] == "" {
+				clio.Infof("Skipping role %s for profile %s as another role was chosen for it", p.profile.RoleName, name)
+				e.skipped(p.profile, "%d profiles rendered to the name %s and another role was chosen for it", len(group), name)
+				continue
+			}
+			if names[i] != name {
+				e.step(p.profile, "renamed to %s as %d profiles rendered to the name %s", names[i], len(group), name)
+				e.renamed(p.profile, names[i])
+			}
+			p.name = names[i]
+			resolved = append(resolved, p)
+		}
+	}
+
+	// make sure resolving the duplicates didn't create any new ones
+	seen := make(map[string]*AccountProfile)
+	for _, p := range resolved {
+		if other, ok := seen[p.name]; ok {
+			return nil, fmt.Errorf("profiles %s still render to the same name %s after resolving duplicates", strings.Join(profileIDs([]*AccountProfile{other, p.profile}), " and "), p.name)
+		}
+		seen[p.name] = p.profile
+	}
+
+	return resolved, nil
+}
+
+func profileIDs(profiles []*AccountProfile) []string {
+	ids := make([]string, len(profiles))
+	for i, p := range profiles {
+		ids[i] = p.AccountID + "/" + p.RoleName
+	}
+	return ids
+}
+
+func profileRoles(profiles []*AccountProfile) []string {
+	roles := make([]string, len(profiles))
+	for i, p := range profiles {
+		roles[i] = p.RoleName
+	}
+	return roles
+}
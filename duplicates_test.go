@@ -0,0 +1,150 @@
+package awsconfigfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_DuplicateResolver(t *testing.T) {
+	profiles := func() []SSOProfile {
+		return []SSOProfile{
+			&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				SSORegion:     "ap-southeast-2",
+				AccountID:     "123456789012",
+				AccountName:   "prod",
+				RoleName:      "DevRole",
+				GeneratedFrom: "aws-sso",
+			},
+			&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				SSORegion:     "ap-southeast-2",
+				AccountID:     "123456789012",
+				AccountName:   "prod",
+				RoleName:      "ReadOnly",
+				GeneratedFrom: "aws-sso",
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		resolver    DuplicateResolver
+		preferRoles []string
+		wantNames   []string
+		wantRoles   []string
+		wantErr     bool
+	}{
+		{
+			name:      "last wins by default",
+			wantNames: []string{"prod"},
+			wantRoles: []string{"ReadOnly"},
+		},
+		{
+			name:        "prefer roles",
+			preferRoles: []string{"Dev.*"},
+			resolver:    DuplicateError,
+			wantNames:   []string{"prod"},
+			wantRoles:   []string{"DevRole"},
+		},
+		{
+			name:     "error",
+			resolver: DuplicateError,
+			wantErr:  true,
+		},
+		{
+			name:      "append role name",
+			resolver:  DuplicateAppendRoleName,
+			wantNames: []string{"prod-DevRole", "prod-ReadOnly"},
+			wantRoles: []string{"DevRole", "ReadOnly"},
+		},
+		{
+			name:      "append hash",
+			resolver:  DuplicateAppendHash,
+			wantNames: []string{"prod-0f5ec773", "prod-ea0a804c"},
+			wantRoles: []string{"DevRole", "ReadOnly"},
+		},
+		{
+			name:     "append account id still clashes",
+			resolver: DuplicateAppendAccountID,
+			wantErr:  true,
+		},
+		{
+			name:      "keep first",
+			resolver:  DuplicateKeepFirst,
+			wantNames: []string{"prod"},
+			wantRoles: []string{"DevRole"},
+		},
+		{
+			name: "custom",
+			resolver: DuplicateResolverFunc(func(name string, profiles []*AccountProfile) ([]string, error) {
+				return []string{name + "-dev", name + "-ro"}, nil
+			}),
+			wantNames: []string{"prod-dev", "prod-ro"},
+			wantRoles: []string{"DevRole", "ReadOnly"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, "")
+			_, err := MergeWithReport(MergeOpts{
+				Config:              cfg,
+				Profiles:            profiles(),
+				SectionNameTemplate: "{{ .AccountName }}",
+				PreferRoles:         tt.preferRoles,
+				DuplicateResolver:   tt.resolver,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var gotNames, gotRoles []string
+			for _, sec := range cfg.Sections() {
+				if !strings.HasPrefix(sec.Name(), "profile ") {
+					continue
+				}
+				gotNames = append(gotNames, strings.TrimPrefix(sec.Name(), "profile "))
+				gotRoles = append(gotRoles, sec.Key("granted_sso_role_name").String())
+			}
+			assert.Equal(t, tt.wantNames, gotNames)
+			assert.Equal(t, tt.wantRoles, gotRoles)
+		})
+	}
+}
+
+func TestMerge_DuplicateReport(t *testing.T) {
+	cfg := parseIni(t, "")
+	report, err := MergeWithReport(MergeOpts{
+		Config:              cfg,
+		SectionNameTemplate: "{{ .AccountName }}",
+		DuplicateResolver:   DuplicateKeepFirst,
+		Profiles: []SSOProfile{
+			&AccountProfile{AccountID: "123456789012", AccountName: "prod", RoleName: "DevRole"},
+			&AccountProfile{AccountID: "123456789012", AccountName: "prod", RoleName: "ReadOnly"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []DuplicateResolution{
+		{
+			ProfileName:   "prod",
+			Profiles:      []string{"123456789012/DevRole", "123456789012/ReadOnly"},
+			ResolvedNames: []string{"prod", ""},
+		},
+	}, report.Duplicates)
+
+	var b bytes.Buffer
+	_, err = cfg.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, b.String(), "granted_sso_role_name      = DevRole")
+	assert.NotContains(t, b.String(), "ReadOnly")
+}
@@ -0,0 +1,163 @@
+package awsconfigfile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ProfileExplanation describes how Merge handled a single account profile:
+// where its name came from, which rules applied to it, and what was written.
+type ProfileExplanation struct {
+	// Source is the profile as it was provided to Merge.
+	Source AccountProfile
+	// TemplateInput is the profile data the profile name template was rendered with,
+	// after normalization and overrides were applied.
+	TemplateInput AccountProfile
+	// RenderedName is the output of the profile name template.
+	RenderedName string
+	// ProfileName is the final profile name, after the prefix, overrides, duplicate resolution
+	// and conflict handling were applied.
+	ProfileName string
+	// Steps describes each rule which applied to the profile, in order.
+	Steps []string
+	// SectionName is the name of the section which was written.
+	// It is empty if no section was written.
+	SectionName string
+	// Section is the content of the section which was written, in ini format.
+	Section string
+	// SkipReason explains why no section was written.
+	SkipReason string
+}
+
+// String formats the explanation for display.
+func (e ProfileExplanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Profile %s/%s\n", e.Source.AccountID, e.Source.RoleName)
+	fmt.Fprintf(&b, "  source: account name %q, role %q, generated from %q\n", e.Source.AccountName, e.Source.RoleName, e.Source.GeneratedFrom)
+	fmt.Fprintf(&b, "  template input: account name %q, role %q\n", e.TemplateInput.AccountName, e.TemplateInput.RoleName)
+	fmt.Fprintf(&b, "  rendered name: %s\n", e.RenderedName)
+	fmt.Fprintf(&b, "  profile name: %s\n", e.ProfileName)
+	for _, step := range e.Steps {
+		fmt.Fprintf(&b, "  - %s\n", step)
+	}
+	if e.SectionName == "" {
+		fmt.Fprintf(&b, "  not written: %s\n", e.SkipReason)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "  written:\n")
+	for _, line := range strings.Split(strings.TrimSpace(e.Section), "\n") {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+	return b.String()
+}
+
+// matches returns true if the query is the account ID, the role name,
+// or <account ID>/<role name> of the profile.
+func (e ProfileExplanation) matches(query string) bool {
+	return query == e.Source.AccountID ||
+		query == e.Source.RoleName ||
+		query == e.Source.AccountID+"/"+e.Source.RoleName
+}
+
+// explainer records how each account profile is handled during a merge.
+// All of its methods are no-ops on a nil explainer, so Merge can call them unconditionally.
+type explainer struct {
+	order  []*AccountProfile
+	traces map[*AccountProfile]*ProfileExplanation
+}
+
+func (e *explainer) start(p *AccountProfile) {
+	if e == nil {
+		return
+	}
+	if e.traces == nil {
+		e.traces = make(map[*AccountProfile]*ProfileExplanation)
+	}
+	e.order = append(e.order, p)
+	e.traces[p] = &ProfileExplanation{Source: *p, SkipReason: "the profile was not processed"}
+}
+
+func (e *explainer) step(p *AccountProfile, format string, args ...any) {
+	if e == nil {
+		return
+	}
+	if t, ok := e.traces[p]; ok {
+		t.Steps = append(t.Steps, fmt.Sprintf(format, args...))
+	}
+}
+
+func (e *explainer) rendered(p *AccountProfile, renderedName string, profileName string) {
+	if e == nil {
+		return
+	}
+	if t, ok := e.traces[p]; ok {
+		t.TemplateInput = *p
+		t.RenderedName = renderedName
+		t.ProfileName = profileName
+	}
+}
+
+func (e *explainer) renamed(p *AccountProfile, profileName string) {
+	if e == nil {
+		return
+	}
+	if t, ok := e.traces[p]; ok {
+		t.ProfileName = profileName
+	}
+}
+
+func (e *explainer) skipped(p *AccountProfile, format string, args ...any) {
+	if e == nil {
+		return
+	}
+	if t, ok := e.traces[p]; ok {
+		t.SkipReason = fmt.Sprintf(format, args...)
+	}
+}
+
+func (e *explainer) written(p *AccountProfile, sec *ini.Section) {
+	if e == nil {
+		return
+	}
+	t, ok := e.traces[p]
+	if !ok {
+		return
+	}
+	t.SectionName = sec.Name()
+	t.SkipReason = ""
+
+	f := ini.Empty(LoadOptions)
+	out, err := f.NewSection(sec.Name())
+	if err != nil {
+		return
+	}
+	for _, k := range sec.Keys() {
+		_ = copyKey(out, k)
+	}
+	var b bytes.Buffer
+	_, _ = f.WriteTo(&b)
+	t.Section = b.String()
+}
+
+// Explain runs a merge against a copy of the config and describes how the profiles
+// matching the query were handled. The query is an AWS account ID, a role name,
+// or <account ID>/<role name>. Neither opts.Config nor opts.Profiles are modified.
+func Explain(opts MergeOpts, query string) ([]ProfileExplanation, error) {
+	e := &explainer{}
+	opts.explainer = e
+	_, _, err := MergeCopy(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ProfileExplanation
+	for _, p := range e.order {
+		if t := e.traces[p]; t.matches(query) {
+			result = append(result, *t)
+		}
+	}
+	return result, nil
+}
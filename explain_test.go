@@ -0,0 +1,94 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	config := `
+[profile prod/Admin]
+region = us-east-1
+`
+	cfg := parseIni(t, config)
+	admin := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		SSORegion:     "ap-southeast-2",
+		AccountID:     "123456789012",
+		AccountName:   "prod account",
+		RoleName:      "Admin",
+		GeneratedFrom: "aws-sso",
+	}
+	readOnly := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		SSORegion:     "ap-southeast-2",
+		AccountID:     "123456789012",
+		AccountName:   "prod account",
+		RoleName:      "ReadOnly",
+		GeneratedFrom: "aws-sso",
+	}
+
+	got, err := Explain(MergeOpts{
+		Config:        cfg,
+		Profiles:      []SSOProfile{admin, readOnly},
+		DefaultRegion: "us-west-2",
+		Overrides: []ProfileOverride{
+			{AccountID: "123456789012", AccountName: "prod"},
+			{AccountID: "123456789012", RoleName: "ReadOnly", Suppress: true},
+		},
+		ConflictPolicy: ConflictRename,
+	}, "123456789012")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ProfileExplanation{
+		{
+			Source:        *admin,
+			TemplateInput: AccountProfile{SSOStartURL: "https://example.awsapps.com/start", SSORegion: "ap-southeast-2", AccountID: "123456789012", AccountName: "prod", RoleName: "Admin", GeneratedFrom: "aws-sso", Region: "us-west-2"},
+			RenderedName:  "prod/Admin",
+			ProfileName:   "prod/Admin-generated",
+			Steps: []string{
+				"matched overrides for 123456789012/Admin",
+				"override replaced the account name with prod",
+				"using the default region us-west-2",
+				"renamed to prod/Admin-generated as the section [profile prod/Admin] was written by hand",
+			},
+			SectionName: "profile prod/Admin-generated",
+			Section: `[profile prod/Admin-generated]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = Admin
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/Admin-generated
+region                     = us-west-2
+common_fate_format_version = 1
+`,
+		},
+		{
+			Source:        *readOnly,
+			TemplateInput: AccountProfile{SSOStartURL: "https://example.awsapps.com/start", SSORegion: "ap-southeast-2", AccountID: "123456789012", AccountName: "prod", RoleName: "ReadOnly", GeneratedFrom: "aws-sso", Region: "us-west-2"},
+			RenderedName:  "prod/ReadOnly",
+			ProfileName:   "prod/ReadOnly",
+			Steps: []string{
+				"matched overrides for 123456789012/ReadOnly",
+				"override replaced the account name with prod",
+				"using the default region us-west-2",
+			},
+			SkipReason: "the profile is suppressed by an override for 123456789012/ReadOnly",
+		},
+	}
+	assert.Equal(t, want, got)
+
+	// the config and profiles which were passed in must not be modified
+	assertIni(t, cfg, config)
+	assert.Equal(t, "prod account", admin.AccountName)
+
+	got, err = Explain(MergeOpts{Config: cfg, Profiles: []SSOProfile{admin}}, "ReadOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, got)
+}
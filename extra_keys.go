@@ -0,0 +1,102 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/common-fate/clio"
+	"gopkg.in/ini.v1"
+)
+
+// knownProfileKeys are the AWS config file settings which can be written as extra keys on a profile.
+var knownProfileKeys = map[string]bool{
+	"account_id_endpoint_mode":           true,
+	"ca_bundle":                          true,
+	"cli_auto_prompt":                    true,
+	"cli_binary_format":                  true,
+	"cli_history":                        true,
+	"cli_pager":                          true,
+	"cli_timestamp_format":               true,
+	"defaults_mode":                      true,
+	"disable_request_compression":        true,
+	"duration_seconds":                   true,
+	"endpoint_url":                       true,
+	"ignore_configure_endpoint_urls":     true,
+	"max_attempts":                       true,
+	"metadata_service_num_attempts":      true,
+	"metadata_service_timeout":           true,
+	"output":                             true,
+	"parameter_validation":               true,
+	"request_checksum_calculation":       true,
+	"request_min_compression_size_bytes": true,
+	"response_checksum_validation":       true,
+	"retry_mode":                         true,
+	"sdk_ua_app_id":                      true,
+	"services":                           true,
+	"sts_regional_endpoints":             true,
+	"tcp_keepalive":                      true,
+	"use_dualstack_endpoint":             true,
+	"use_fips_endpoint":                  true,
+}
+
+// extraKeysEntry is implemented by ini entries which write extra keys in addition to their fields.
+type extraKeysEntry interface {
+	extraKeys() map[string]string
+}
+
+// validateExtraKeys returns an error if any of the extra keys would replace a generated key,
+// and logs a warning for keys which aren't known AWS config settings.
+func validateExtraKeys(extra map[string]string) error {
+	for k, v := range extra {
+		if !validKeyName(k) {
+			return fmt.Errorf("invalid extra key %q", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("extra key %s must not contain a newline", k)
+		}
+		if builtinGeneratedKeys[k] {
+			return fmt.Errorf("extra key %s can't be used as it is generated", k)
+		}
+		if !knownProfileKeys[k] {
+			clio.Warnf("Extra key %s is not a known AWS config setting", k)
+		}
+	}
+	return nil
+}
+
+// validKeyName returns true if name can be written as an ini key.
+func validKeyName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " =[]\t\r\n")
+}
+
+// mergeExtraKeys returns the default extra keys overlaid with the profile's own.
+func mergeExtraKeys(defaults map[string]string, extra map[string]string) map[string]string {
+	if len(defaults) == 0 && len(extra) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(defaults)+len(extra))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
+
+// writeExtraKeys adds the extra keys to a rendered section, in sorted order.
+func writeExtraKeys(sec *ini.Section, extra map[string]string) error {
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, err := sec.NewKey(k, extra[k])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
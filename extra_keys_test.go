@@ -0,0 +1,134 @@
+package awsconfigfile
+
+import (
+	"testing"
+)
+
+func TestMerge_ExtraKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		extra      map[string]string
+		defaults   map[string]string
+		noCredProc bool
+		config     string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:     "defaults and profile settings are written",
+			defaults: map[string]string{"output": "json", "retry_mode": "standard"},
+			extra:    map[string]string{"output": "yaml", "max_attempts": "5"},
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+max_attempts               = 5
+output                     = yaml
+retry_mode                 = standard
+common_fate_format_version = 1
+common_fate_generated_keys = max_attempts,output,retry_mode
+`,
+		},
+		{
+			name:       "no credential process",
+			extra:      map[string]string{"cli_pager": "less"},
+			noCredProc: true,
+			want: `
+[sso-session example]
+sso_start_url              = https://example.awsapps.com/start
+sso_registration_scopes    = 
+sso_region                 = 
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+
+[profile prod/DevRole]
+sso_session                = example
+sso_account_id             = 123456789012
+common_fate_generated_from = aws-sso
+sso_role_name              = DevRole
+cli_pager                  = less
+common_fate_format_version = 1
+common_fate_generated_keys = cli_pager
+`,
+		},
+		{
+			name: "extra keys are removed when they are no longer set",
+			config: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+output                     = yaml
+ca_bundle                  = /etc/ssl/bundle.pem
+common_fate_format_version = 1
+common_fate_generated_keys = output
+`,
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+ca_bundle                  = /etc/ssl/bundle.pem
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:    "generated keys can't be replaced",
+			extra:   map[string]string{"credential_process": "evil"},
+			wantErr: true,
+		},
+		{
+			name:     "invalid keys are rejected",
+			defaults: map[string]string{"bad key": "value"},
+			wantErr:  true,
+		},
+		{
+			name:  "unknown keys are written",
+			extra: map[string]string{"my_custom_setting": "true"},
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+my_custom_setting          = true
+common_fate_format_version = 1
+common_fate_generated_keys = my_custom_setting
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			err := Merge(MergeOpts{
+				Config: cfg,
+				Profiles: []SSOProfile{&AccountProfile{
+					SSOStartURL:   "https://example.awsapps.com/start",
+					AccountID:     "123456789012",
+					AccountName:   "prod",
+					RoleName:      "DevRole",
+					GeneratedFrom: "aws-sso",
+					Extra:         tt.extra,
+				}},
+				ExtraKeys:           tt.defaults,
+				NoCredentialProcess: tt.noCredProc,
+				SessionName:         "example",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package awsconfigfile
+
+import (
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// generatedKeysKey lists the keys in a generated section which are owned by Merge,
+// other than the ones in builtinGeneratedKeys. It is only written when there are any.
+const generatedKeysKey = "common_fate_generated_keys"
+
+// namespaceKey records the namespace of the generator which wrote a section.
+// It is omitted for the default, empty, namespace.
+const namespaceKey = "common_fate_namespace"
+
+// builtinGeneratedKeys are the keys written by the generated profile and sso-session types.
+// They are always treated as owned by Merge in generated sections.
+// Keys which only some kinds of profile write, such as role_arn, are recorded in
+// common_fate_generated_keys instead, so that they can still be added by hand to other kinds.
+var builtinGeneratedKeys = map[string]bool{
+	"common_fate_generated_from": true,
+	"common_fate_source":         true,
+	"credential_process":         true,
+	"granted_sso_account_id":     true,
+	"granted_sso_region":         true,
+	"granted_sso_role_name":      true,
+	"granted_sso_start_url":      true,
+	mirrorOfKey:                  true,
+	"region":                     true,
+	"sso_account_id":             true,
+	"sso_region":                 true,
+	"sso_registration_scopes":    true,
+	"sso_role_name":              true,
+	"sso_session":                true,
+	"sso_start_url":              true,
+	generatedKeysKey:             true,
+	baseProfileKey:               true,
+	namespaceKey:                 true,
+	formatVersionKey:             true,
+	contentHashKey:               true,
+	generatedAtKey:               true,
+	staleSinceKey:                true,
+}
+
+// isGeneratedSection returns true if the section was created automatically by Merge.
+func isGeneratedSection(sec *ini.Section) bool {
+	return sec.HasKey("common_fate_generated_from")
+}
+
+// isOwnedSection returns true if the section was created by Merge in the given namespace.
+// Sections generated in other namespaces belong to other generators and are left alone.
+func isOwnedSection(sec *ini.Section, namespace string) bool {
+	return isGeneratedSection(sec) && keyValue(sec, namespaceKey) == namespace
+}
+
+// isManualSection returns true if the config contains the named section
+// and it was not created by Merge in the given namespace.
+func isManualSection(cfg *ini.File, sectionName string, namespace string) bool {
+	sec, err := cfg.GetSection(sectionName)
+	if err != nil {
+		return false
+	}
+	return !isOwnedSection(sec, namespace)
+}
+
+// keyValue returns the value of a key in the section, or an empty string if it isn't set.
+// Unlike Section.Key, it doesn't create the key if it is missing.
+func keyValue(sec *ini.Section, name string) string {
+	if !sec.HasKey(name) {
+		return ""
+	}
+	return sec.Key(name).String()
+}
+
+// ownedKeys returns the keys in a generated section which were written by Merge.
+func ownedKeys(sec *ini.Section) map[string]bool {
+	owned := make(map[string]bool, len(builtinGeneratedKeys))
+	for k := range builtinGeneratedKeys {
+		owned[k] = true
+	}
+	for _, k := range strings.Split(keyValue(sec, generatedKeysKey), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			owned[k] = true
+		}
+	}
+	return owned
+}
+
+// pendingSections are the changes a merge makes to the sections of the config.
+// They are planned first and written together once every profile has been checked,
+// so that a merge which fails leaves the config as it was.
+type pendingSections struct {
+	cfg     *ini.File
+	meta    sectionMetadata
+	deleted []string
+	writes  []pendingSection
+}
+
+type pendingSection struct {
+	generated *ini.Section
+	// written is called with the section once it has been written to the config, if it is set.
+	written func(sec *ini.Section)
+}
+
+func newPendingSections(cfg *ini.File, meta sectionMetadata) *pendingSections {
+	return &pendingSections{cfg: cfg, meta: meta}
+}
+
+// delete removes the named section from the config before the generated sections are written.
+func (p *pendingSections) delete(sectionName string) {
+	p.deleted = append(p.deleted, sectionName)
+}
+
+// add writes a rendered section to the config.
+// If the section already exists, only the keys owned by Merge are updated.
+// Any other keys, such as ones added by hand, are carried over unchanged.
+func (p *pendingSections) add(generated *ini.Section, written func(sec *ini.Section)) {
+	p.writes = append(p.writes, pendingSection{generated: generated, written: written})
+}
+
+// addEntry writes the ini representation of entry to the named section.
+func (p *pendingSections) addEntry(sectionName string, entry any) error {
+	generated, err := renderSection(sectionName, entry)
+	if err != nil {
+		return err
+	}
+	p.add(generated, nil)
+	return nil
+}
+
+// sourceProfile returns the source_profile the named section will have once the pending changes are written.
+func (p *pendingSections) sourceProfile(sectionName string) string {
+	rewritten := false
+	for _, w := range p.writes {
+		if w.generated.Name() == sectionName {
+			if w.generated.HasKey("source_profile") {
+				return keyValue(w.generated, "source_profile")
+			}
+			rewritten = true
+		}
+	}
+	existing, err := p.cfg.GetSection(sectionName)
+	if err != nil || slices.Contains(p.deleted, sectionName) {
+		return ""
+	}
+	if rewritten && ownedKeys(existing)["source_profile"] {
+		return ""
+	}
+	return keyValue(existing, "source_profile")
+}
+
+// sectionNames returns the names of the sections due to be written.
+func (p *pendingSections) sectionNames() []string {
+	names := make([]string, len(p.writes))
+	for i, w := range p.writes {
+		names[i] = w.generated.Name()
+	}
+	return names
+}
+
+// write applies the pending changes to the config.
+func (p *pendingSections) write() error {
+	for _, name := range p.deleted {
+		p.cfg.DeleteSection(name)
+	}
+	for _, w := range p.writes {
+		sec, err := applyGeneratedSection(p.cfg, w.generated, p.meta)
+		if err != nil {
+			return err
+		}
+		if w.written != nil {
+			w.written(sec)
+		}
+	}
+	return nil
+}
+
+// renderSection returns the ini representation of entry in a standalone section,
+// so that it can be adjusted before being written to the config.
+func renderSection(sectionName string, entry any) (*ini.Section, error) {
+	generated, err := ini.Empty(LoadOptions).NewSection(sectionName)
+	if err != nil {
+		return nil, err
+	}
+	err = generated.ReflectFrom(entry)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := entry.(extraKeysEntry); ok {
+		err = writeExtraKeys(generated, e.extraKeys())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e, ok := entry.(nestedKeysEntry); ok {
+		err = writeNestedKeys(generated, e.nestedKeys())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return generated, nil
+}
+
+// applyGeneratedSection writes a rendered section to the config,
+// updating only the keys owned by Merge if the section already exists.
+// The metadata, such as the namespace and format version, is added to the section.
+func applyGeneratedSection(cfg *ini.File, generated *ini.Section, meta sectionMetadata) (*ini.Section, error) {
+	sectionName := generated.Name()
+	section, err := cfg.GetSection(sectionName)
+	if err != nil {
+		err = meta.add(generated, nil)
+		if err != nil {
+			return nil, err
+		}
+		section, err = cfg.NewSection(sectionName)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = meta.add(generated, section)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	owned := ownedKeys(section)
+	for _, k := range section.KeyStrings() {
+		if owned[k] && !generated.HasKey(k) {
+			section.DeleteKey(k)
+		}
+	}
+
+	var extraKeys []string
+	for _, k := range generated.Keys() {
+		err = copyKey(section, k)
+		if err != nil {
+			return nil, err
+		}
+		if !builtinGeneratedKeys[k.Name()] {
+			extraKeys = append(extraKeys, k.Name())
+		}
+	}
+
+	if len(extraKeys) > 0 {
+		_, err = section.NewKey(generatedKeysKey, strings.Join(extraKeys, ","))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return section, nil
+}
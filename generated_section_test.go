@@ -0,0 +1,79 @@
+package awsconfigfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestPendingSectionsWrite(t *testing.T) {
+	type withOutput struct {
+		CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+		Region                  string `ini:"region,omitempty"`
+		Output                  string `ini:"output,omitempty"`
+	}
+
+	cfg := parseIni(t, `
+[profile example]
+retry_mode = standard
+`)
+
+	pending := newPendingSections(cfg, sectionMetadata{})
+	err := pending.addEntry("profile example", &withOutput{CommonFateGeneratedFrom: "aws-sso", Region: "us-east-1", Output: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pending.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, `
+[profile example]
+retry_mode                 = standard
+common_fate_generated_from = aws-sso
+region                     = us-east-1
+output                     = json
+common_fate_format_version = 1
+common_fate_generated_keys = output
+`)
+
+	// keys which are no longer generated are removed, while others are kept
+	pending = newPendingSections(cfg, sectionMetadata{})
+	err = pending.addEntry("profile example", &withOutput{CommonFateGeneratedFrom: "aws-sso"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pending.write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, `
+[profile example]
+retry_mode                 = standard
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+`)
+}
+
+func assertIni(t *testing.T, cfg *ini.File, want string) {
+	t.Helper()
+	assert.Equal(t, trimIni(want), writeIni(t, cfg))
+}
+
+func writeIni(t *testing.T, cfg *ini.File) string {
+	t.Helper()
+	var b bytes.Buffer
+	_, err := cfg.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trimIni(b.String())
+}
+
+// trimIni ignores leading/trailing whitespace so it's easier to format ini files in tests
+func trimIni(s string) string {
+	return strings.TrimSpace(s)
+}
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/ini.v1"
@@ -16,9 +17,28 @@ type Source interface {
 	GetProfiles(ctx context.Context) ([]SSOProfile, error)
 }
 
+// IdentifiedSource is a Source with a stable ID.
+// The ID is recorded in each section generated from the source's profiles,
+// so that the source can prune its own profiles with PruneSourceIDs.
+type IdentifiedSource interface {
+	Source
+	SourceID() string
+}
+
+// SourceIDProfile is implemented by profiles which record the ID of the source they were returned by.
+// Custom profile kinds implement it to be pruned with PruneSourceIDs when their source is an IdentifiedSource.
+type SourceIDProfile interface {
+	Profile
+	// WithSourceID returns a copy of the profile with the source ID set, unless it has one already.
+	WithSourceID(sourceID string) Profile
+}
+
 // Generator generates AWS profiles for ~/.aws/config.
 // It reads profiles from sources and merges them with
 // an existing ini config file.
+//
+// A Generator can be reused, and its methods can be called concurrently.
+// Merges into Config are serialized.
 type Generator struct {
 	Sources             []Source
 	Config              *ini.File
@@ -28,15 +48,67 @@ type Generator struct {
 	// PruneStartURLs is a slice of AWS SSO start URLs which profiles are being generated for.
 	// Existing profiles with these start URLs will be removed if they aren't found in the Profiles field.
 	PruneStartURLs []string
+	// PruneSourceIDs is a slice of source IDs which profiles are being generated for.
+	// See MergeOpts.PruneSourceIDs.
+	PruneSourceIDs []string
+	// Namespace separates the profiles generated by this generator from the ones generated
+	// by other tools writing to the same config file. See MergeOpts.Namespace.
+	Namespace      string
 	SessionName    string
 	SSOScopes      []string
 	PreferRoles    []string
 	Verbose 			bool
 	DefaultRegion string
+	// ConflictPolicy controls what happens when a generated profile name matches
+	// a profile section which was written by hand. Defaults to ConflictOverwrite.
+	ConflictPolicy ConflictPolicy
+	// ConflictSuffix is appended to generated profile names when ConflictPolicy is ConflictRename.
+	ConflictSuffix string
+	// Overrides are permanent per-profile exceptions which are applied on top of the generated values.
+	Overrides []ProfileOverride
+	// PinnedProfiles is a list of profile names or AWS account IDs which must not be updated or pruned.
+	PinnedProfiles []string
+	// DuplicateResolver decides what happens when several profiles render to the same name.
+	DuplicateResolver DuplicateResolver
+	// RolePreferences rank roles to decide between profiles which render to the same name.
+	RolePreferences []RolePreference
+	// PruneGracePeriod keeps generated sections which are no longer returned by the sources
+	// for a period of time before pruning them.
+	PruneGracePeriod time.Duration
+	// MaxPruneCount and MaxPrunePercent stop a merge from pruning a large number of profiles,
+	// which usually means a source failed to return them. ForcePrune overrides them.
+	MaxPruneCount   int
+	MaxPrunePercent float64
+	ForcePrune      bool
+	// RecordContentHash and RecordGenerationTime add a content hash and
+	// the time the values last changed to each generated section.
+	RecordContentHash    bool
+	RecordGenerationTime bool
+	// AssumeRoleChains generates profiles which assume a role in each account returned by the sources.
+	AssumeRoleChains []AssumeRoleChain
+	// CredentialProcessTemplate and VerifyCredentialProcess customise the credential_process
+	// of generated profiles. See MergeOpts.CredentialProcessTemplate.
+	CredentialProcessTemplate string
+	VerifyCredentialProcess   bool
+	// ExtraKeys are additional settings written to every generated account profile.
+	ExtraKeys map[string]string
+	// NestedKeys are nested settings, such as the s3 settings, written to every generated account profile.
+	NestedKeys map[string]map[string]string
+	// LocalStack generates a LocalStack twin of every generated account profile. See MergeOpts.LocalStack.
+	LocalStack *LocalStack
+	// BaseProfile and BaseProfileRules name hand-written profiles whose keys are copied
+	// into the generated profiles. See MergeOpts.BaseProfile.
+	BaseProfile      string
+	BaseProfileRules []BaseProfileRule
+
+	// mu guards Sources and Config.
+	mu sync.Mutex
 }
 
 // AddSource adds a new source to load profiles from to the generator.
 func (g *Generator) AddSource(source Source) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	g.Sources = append(g.Sources, source)
 }
 
@@ -53,34 +125,108 @@ var DefaultProfileNameTemplate = "{{ .AccountName }}/{{ .RoleName }}"
 // Generate AWS profiles and merge them with the existing config.
 // Writes output to the generator's output.
 func (g *Generator) Generate(ctx context.Context) error {
+	_, err := g.GenerateWithReport(ctx)
+	return err
+}
+
+// GenerateWithReport generates AWS profiles and merges them with the existing config, like Generate,
+// and returns a report of the decisions which were made during the merge.
+func (g *Generator) GenerateWithReport(ctx context.Context) (*MergeReport, error) {
+	opts, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	opts.Config = g.Config
+	return MergeWithReport(opts)
+}
+
+// GenerateConfig generates AWS profiles and merges them with a copy of the existing config,
+// returning the copy. The generator's Config is not modified.
+func (g *Generator) GenerateConfig(ctx context.Context) (*ini.File, *MergeReport, error) {
+	opts, err := g.prepare(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	g.mu.Lock()
+	cfg, err := cloneConfig(g.Config)
+	g.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.Config = cfg
+	report, err := MergeWithReport(opts)
+	if err != nil {
+		return nil, report, err
+	}
+	return cfg, report, nil
+}
+
+// Explain loads profiles from the sources and describes how the ones matching the query
+// would be merged into the config, without modifying it.
+// The query is an AWS account ID, a role name, or <account ID>/<role name>.
+func (g *Generator) Explain(ctx context.Context, query string) ([]ProfileExplanation, error) {
+	opts, err := g.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	opts.Config = g.Config
+	return Explain(opts, query)
+}
+
+// prepare validates the generator's options and loads profiles from its sources,
+// returning the options to merge them with. The caller sets the config to merge into.
+// The generator itself isn't modified.
+func (g *Generator) prepare(ctx context.Context) (MergeOpts, error) {
 	var eg errgroup.Group
 	var mu sync.Mutex
 	var profiles []SSOProfile
 
 	if strings.ContainsAny(g.Prefix, profileSectionIllegalChars) {
-		return fmt.Errorf("profile prefix must not contain any of these illegal characters (%s)", profileSectionIllegalChars)
+		return MergeOpts{}, fmt.Errorf("profile prefix must not contain any of these illegal characters (%s)", profileSectionIllegalChars)
 	}
 
 	// use the default template if it's not provided
-	if g.ProfileNameTemplate == "" {
-		g.ProfileNameTemplate = DefaultProfileNameTemplate
+	profileNameTemplate := g.ProfileNameTemplate
+	if profileNameTemplate == "" {
+		profileNameTemplate = DefaultProfileNameTemplate
 	}
 
 	// check the profile template for any invalid section name characters
-	if g.ProfileNameTemplate != DefaultProfileNameTemplate {
-		cleaned := matchGoTemplateSection.ReplaceAllString(g.ProfileNameTemplate, "")
+	if profileNameTemplate != DefaultProfileNameTemplate {
+		cleaned := matchGoTemplateSection.ReplaceAllString(profileNameTemplate, "")
 		if profileSectionIllegalCharsRegex.MatchString(cleaned) {
-			return fmt.Errorf("profile template must not contain any of these illegal characters (%s)", profileSectionIllegalChars)
+			return MergeOpts{}, fmt.Errorf("profile template must not contain any of these illegal characters (%s)", profileSectionIllegalChars)
 		}
 	}
 
-	for _, s := range g.Sources {
+	// check the role preferences before loading any profiles, as an invalid pattern would otherwise match nothing
+	err := ValidateRolePreferences(g.PreferRoles, g.RolePreferences)
+	if err != nil {
+		return MergeOpts{}, err
+	}
+	err = ValidateCredentialProcessTemplate(g.CredentialProcessTemplate)
+	if err != nil {
+		return MergeOpts{}, err
+	}
+
+	g.mu.Lock()
+	sources := append([]Source{}, g.Sources...)
+	g.mu.Unlock()
+
+	for _, s := range sources {
 		scopy := s
 		eg.Go(func() error {
 			got, err := scopy.GetProfiles(ctx)
 			if err != nil {
 				return err
 			}
+			if s, ok := scopy.(IdentifiedSource); ok {
+				got = withSourceID(got, s.SourceID())
+			}
 			mu.Lock()
 			defer mu.Unlock()
 			profiles = append(profiles, got...)
@@ -88,23 +234,61 @@ func (g *Generator) Generate(ctx context.Context) error {
 		})
 	}
 
-	err := eg.Wait()
+	err = eg.Wait()
 	if err != nil {
-		return err
+		return MergeOpts{}, err
 	}
 
-	err = Merge(MergeOpts{
-		Config:              g.Config,
-		SectionNameTemplate: g.ProfileNameTemplate,
+	if len(g.AssumeRoleChains) > 0 {
+		profiles = AssumeRoleChains(profiles, g.AssumeRoleChains)
+	}
+
+	return MergeOpts{
+		SectionNameTemplate: profileNameTemplate,
 		Profiles:            profiles,
 		NoCredentialProcess: g.NoCredentialProcess,
 		Prefix:              g.Prefix,
 		PruneStartURLs:      g.PruneStartURLs,
+		PruneSourceIDs:      g.PruneSourceIDs,
+		Namespace:           g.Namespace,
 		SessionName:         g.SessionName,
 		SSOScopes: 				   g.SSOScopes,
 		PreferRoles:         g.PreferRoles,
 		Verbose:             g.Verbose,
 		DefaultRegion:       g.DefaultRegion,
-	})
-	return err
+		ConflictPolicy:      g.ConflictPolicy,
+		ConflictSuffix:      g.ConflictSuffix,
+		Overrides:           g.Overrides,
+		PinnedProfiles:      g.PinnedProfiles,
+		DuplicateResolver:   g.DuplicateResolver,
+		RolePreferences:     g.RolePreferences,
+		PruneGracePeriod:    g.PruneGracePeriod,
+		MaxPruneCount:       g.MaxPruneCount,
+		MaxPrunePercent:     g.MaxPrunePercent,
+		ForcePrune:          g.ForcePrune,
+		RecordContentHash:    g.RecordContentHash,
+		RecordGenerationTime: g.RecordGenerationTime,
+		CredentialProcessTemplate: g.CredentialProcessTemplate,
+		VerifyCredentialProcess:   g.VerifyCredentialProcess,
+		ExtraKeys:                 g.ExtraKeys,
+		NestedKeys:                g.NestedKeys,
+		LocalStack:                g.LocalStack,
+		BaseProfile:               g.BaseProfile,
+		BaseProfileRules:          g.BaseProfileRules,
+	}, nil
+}
+
+// withSourceID returns copies of the profiles with the source ID recorded on them,
+// unless they have one already. The source's own profiles aren't modified.
+// Profiles which don't implement SourceIDProfile are returned as they are.
+func withSourceID(profiles []SSOProfile, sourceID string) []SSOProfile {
+	result := make([]SSOProfile, len(profiles))
+	for i, p := range profiles {
+		if s, ok := p.(SourceIDProfile); ok {
+			result[i] = s.WithSourceID(sourceID)
+			continue
+		}
+		result[i] = p
+	}
+	return result
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -59,6 +60,7 @@ granted_sso_account_id     = 123456789012
 granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -89,6 +91,7 @@ granted_sso_account_id     = 123456789012
 granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile myprefix-prod/DevRole
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -122,6 +125,7 @@ granted_sso_account_id     = 123456789012
 granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod.hello
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -146,6 +150,7 @@ granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod/DevRole
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -182,6 +187,7 @@ granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod/DevRole
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -211,6 +217,7 @@ granted_sso_start_url = https://somethingelse.example.com
 [profile should_be_kept]
 common_fate_generated_from = aws-sso
 granted_sso_start_url      = https://somethingelse.example.com
+common_fate_format_version = 1
 
 [profile prod/DevRole]
 granted_sso_start_url      = https://example.awsapps.com/start
@@ -220,6 +227,7 @@ granted_sso_role_name      = DevRole
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod/DevRole
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -253,6 +261,7 @@ granted_sso_role_name      = DevRoleOne
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod/DevRoleOne
 region                     = us-west-2
+common_fate_format_version = 1
 
 [profile prod/DevRoleTwo]
 granted_sso_start_url      = https://example.awsapps.com/start
@@ -262,6 +271,7 @@ granted_sso_role_name      = DevRoleTwo
 common_fate_generated_from = aws-sso
 credential_process         = granted credential-process --profile prod/DevRoleTwo
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 	}
@@ -342,12 +352,14 @@ sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile prod/DevRole]
 sso_session                = company
 sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -382,12 +394,14 @@ sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile myprefix-prod/DevRole]
 sso_session                = company
 sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -425,12 +439,14 @@ sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile prod.hello]
 sso_session                = company
 sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -458,6 +474,7 @@ sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile prod/DevRole]
 sso_session                = company
@@ -465,6 +482,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -504,6 +522,7 @@ sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile prod/DevRole]
 sso_session                = company
@@ -511,6 +530,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -546,12 +566,14 @@ granted_sso_start_url = https://somethingelse.example.com
 [profile should_be_kept]
 common_fate_generated_from = aws-sso
 granted_sso_start_url      = https://somethingelse.example.com
+common_fate_format_version = 1
 
 [sso-session company]
 sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile prod/DevRole]
 sso_session                = company
@@ -559,6 +581,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -594,6 +617,7 @@ sso_start_url              = https://example.awsapps.com/start
 sso_registration_scopes    = sso:account:access
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile prod/DevRoleOne]
 sso_session                = company
@@ -601,6 +625,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRoleOne
 region                     = us-west-2
+common_fate_format_version = 1
 
 [profile prod/DevRoleTwo]
 sso_session                = company
@@ -608,6 +633,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRoleTwo
 region                     = us-west-2
+common_fate_format_version = 1
 `,
 		},
 		{
@@ -635,6 +661,7 @@ sso_start_url              = https://example.com
 sso_registration_scopes    = example-scope
 sso_region                 = ap-southeast-2
 common_fate_generated_from = aws-sso
+common_fate_format_version = 1
 
 [profile testing/DevRole]
 sso_session                = example-session
@@ -642,6 +669,7 @@ sso_account_id             = 123456789012
 common_fate_generated_from = aws-sso
 sso_role_name              = DevRole
 region                     = ap-southeast-2
+common_fate_format_version = 1
 `,
 		},
 	}
@@ -680,3 +708,44 @@ region                     = ap-southeast-2
 		})
 	}
 }
+
+func TestGenerator_Reuse(t *testing.T) {
+	source := testSource{Profiles: []SSOProfile{
+		&AccountProfile{SSOStartURL: "https://example.awsapps.com/start", AccountID: "123456789012", AccountName: "prod", RoleName: "DevRole", GeneratedFrom: "aws-sso"},
+	}}
+	g := &Generator{
+		Sources: []Source{source},
+		Config:  ini.Empty(),
+	}
+	ctx := context.Background()
+
+	cfg, _, err := g.GenerateConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, cfg.HasSection("profile prod/DevRole"))
+	assert.False(t, g.Config.HasSection("profile prod/DevRole"), "GenerateConfig should not modify the generator's config")
+	assert.Equal(t, "", g.ProfileNameTemplate, "the generator's options should not be modified")
+
+	// the generator can be run concurrently, and with different options
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := g.Generate(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.True(t, g.Config.HasSection("profile prod/DevRole"))
+
+	g.ProfileNameTemplate = "{{ .AccountID }}"
+	err = g.Generate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, g.Config.HasSection("profile 123456789012"))
+	assert.Equal(t, "prod", source.Profiles[0].(*AccountProfile).AccountName)
+}
@@ -0,0 +1,261 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/common-fate/clio"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// DefaultLocalStackNamePrefix is added to the profile name of each LocalStack twin.
+	DefaultLocalStackNamePrefix = "local/"
+	// DefaultLocalStackEndpoint is the endpoint LocalStack listens on by default.
+	DefaultLocalStackEndpoint = "http://localhost:4566"
+	// DefaultLocalStackServicesName is the name of the generated [services] section.
+	DefaultLocalStackServicesName = "localstack"
+	// DefaultLocalStackCredential is the access key ID and secret access key of the twins.
+	// LocalStack accepts any credentials.
+	DefaultLocalStackCredential = "test"
+	// DefaultLocalStackRegion is the region of twins whose profile has no region.
+	DefaultLocalStackRegion = "us-east-1"
+)
+
+// mirrorOfKey records the name of the generated profile a LocalStack twin mirrors.
+const mirrorOfKey = "common_fate_mirror_of"
+
+// localStackGeneratedFrom is the common_fate_generated_from value of the generated [services] section.
+const localStackGeneratedFrom = "localstack"
+
+// servicesSectionPrefix is the prefix of [services] section names.
+const servicesSectionPrefix = "services "
+
+// LocalStack configures the LocalStack twins generated for each account profile.
+// A twin has the same name as the profile with a prefix, such as local/prod/DevRole,
+// and uses static test credentials against a LocalStack endpoint,
+// so that switching between real and local targets only means changing the profile name.
+type LocalStack struct {
+	// NamePrefix is added to the profile name of each twin. Defaults to DefaultLocalStackNamePrefix.
+	NamePrefix string
+	// EndpointURL is the LocalStack endpoint. Defaults to DefaultLocalStackEndpoint.
+	EndpointURL string
+	// Services are the service IDs, such as s3 or dynamodb, to send to LocalStack.
+	// If set, a [services] section routing them to the endpoint is generated and referenced by the twins.
+	// Otherwise the twins set endpoint_url, which sends every service to LocalStack.
+	Services []string
+	// ServicesName is the name of the generated [services] section. Defaults to DefaultLocalStackServicesName.
+	ServicesName string
+	// AccessKeyID and SecretAccessKey are the static credentials of the twins.
+	// Both default to DefaultLocalStackCredential.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Region is used for twins whose profile has no region. Defaults to DefaultLocalStackRegion.
+	Region string
+}
+
+// withDefaults returns a copy of the options with the defaults applied.
+func (l LocalStack) withDefaults() LocalStack {
+	if l.NamePrefix == "" {
+		l.NamePrefix = DefaultLocalStackNamePrefix
+	}
+	if l.EndpointURL == "" {
+		l.EndpointURL = DefaultLocalStackEndpoint
+	}
+	if l.ServicesName == "" {
+		l.ServicesName = DefaultLocalStackServicesName
+	}
+	if l.AccessKeyID == "" {
+		l.AccessKeyID = DefaultLocalStackCredential
+	}
+	if l.SecretAccessKey == "" {
+		l.SecretAccessKey = DefaultLocalStackCredential
+	}
+	if l.Region == "" {
+		l.Region = DefaultLocalStackRegion
+	}
+	return l
+}
+
+func (l LocalStack) validate() error {
+	u, err := url.Parse(l.EndpointURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid LocalStack endpoint URL %q", l.EndpointURL)
+	}
+	if strings.ContainsAny(l.NamePrefix, profileSectionIllegalChars) {
+		return fmt.Errorf("LocalStack name prefix must not contain any of these illegal characters (%s)", profileSectionIllegalChars)
+	}
+	if strings.ContainsAny(l.ServicesName, profileSectionIllegalChars) {
+		return fmt.Errorf("LocalStack services name must not contain any of these illegal characters (%s)", profileSectionIllegalChars)
+	}
+	for _, s := range l.Services {
+		if !validKeyName(s) {
+			return fmt.Errorf("invalid LocalStack service %q", s)
+		}
+	}
+	for _, v := range []string{l.AccessKeyID, l.SecretAccessKey, l.Region} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("LocalStack credentials and region must not contain a newline")
+		}
+	}
+	return nil
+}
+
+type localStackProfile struct {
+	AccessKeyID             string `ini:"aws_access_key_id"`
+	SecretAccessKey         string `ini:"aws_secret_access_key"`
+	Region                  string `ini:"region"`
+	EndpointURL             string `ini:"endpoint_url,omitempty"`
+	Services                string `ini:"services,omitempty"`
+	CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
+	MirrorOf                string `ini:"common_fate_mirror_of"`
+}
+
+type localStackServices struct {
+	CommonFateGeneratedFrom string                       `ini:"common_fate_generated_from"`
+	Services                map[string]map[string]string `ini:"-"`
+}
+
+func (s *localStackServices) nestedKeys() map[string]map[string]string {
+	return s.Services
+}
+
+// localStackTwin is a generated account profile which a LocalStack twin is written for.
+type localStackTwin struct {
+	profile     *AccountProfile
+	profileName string
+}
+
+// mergeLocalStack adds a LocalStack twin of each generated account profile,
+// and the [services] section they refer to, to the pending changes.
+func mergeLocalStack(opts MergeOpts, twins []localStackTwin, bases *baseProfiles, pending *pendingSections, written map[string]bool, report *MergeReport) error {
+	if opts.LocalStack == nil {
+		return nil
+	}
+	ls := opts.LocalStack.withDefaults()
+
+	var services string
+	if len(ls.Services) > 0 {
+		services = ls.ServicesName
+	}
+
+	var twinsWritten bool
+	for _, twin := range twins {
+		profileName := ls.NamePrefix + twin.profileName
+		sectionName := accountSectionName(profileName)
+		if written[sectionName] {
+			return fmt.Errorf("LocalStack twin %s renders to the same section as another generated profile: [%s]", profileName, sectionName)
+		}
+		if isPinnedSection(opts.Config, sectionName, opts.PinnedProfiles) {
+			clio.Infof("Skipping profile %s as it is pinned", profileName)
+			report.addPinned(sectionName)
+			twinsWritten = true
+			continue
+		}
+
+		conflict, err := resolveConflict(opts, profileName, sectionName, accountSectionName, pending, report)
+		if err != nil {
+			return err
+		}
+		if conflict != nil && conflict.Decision == ConflictSkip {
+			continue
+		}
+		if conflict != nil && conflict.Decision == ConflictRename {
+			profileName = conflict.RenamedTo
+			sectionName = accountSectionName(profileName)
+		}
+
+		region := twin.profile.Region
+		if region == "" {
+			region = ls.Region
+		}
+		entry := &localStackProfile{
+			AccessKeyID:             ls.AccessKeyID,
+			SecretAccessKey:         ls.SecretAccessKey,
+			Region:                  region,
+			Services:                services,
+			CommonFateGeneratedFrom: twin.profile.GeneratedFrom,
+			CommonFateSource:        twin.profile.SourceID,
+			MirrorOf:                twin.profileName,
+		}
+		if services == "" {
+			entry.EndpointURL = ls.EndpointURL
+		}
+		generated, err := renderSection(sectionName, entry)
+		if err != nil {
+			return err
+		}
+		err = applyBaseProfile(opts, generated, bases.forProfile(twin.profile.AccountID, twin.profile.RoleName, twin.profile.OrganizationalUnit))
+		if err != nil {
+			return err
+		}
+		pending.add(generated, nil)
+		written[sectionName] = true
+		twinsWritten = true
+	}
+
+	if services == "" || !twinsWritten {
+		return nil
+	}
+
+	sectionName := servicesSectionPrefix + services
+	if isPinnedSection(opts.Config, sectionName, opts.PinnedProfiles) {
+		report.addPinned(sectionName)
+		return nil
+	}
+	if isManualSection(opts.Config, sectionName, opts.Namespace) {
+		clio.Warnf("Not updating [%s] as it was not generated, LocalStack twins will use it as it is", sectionName)
+		return nil
+	}
+	entry := &localStackServices{
+		CommonFateGeneratedFrom: localStackGeneratedFrom,
+		Services:                make(map[string]map[string]string, len(ls.Services)),
+	}
+	for _, s := range ls.Services {
+		entry.Services[s] = map[string]string{"endpoint_url": ls.EndpointURL}
+	}
+	err := pending.addEntry(sectionName, entry)
+	if err != nil {
+		return err
+	}
+	written[sectionName] = true
+	return nil
+}
+
+// mirroredSection returns the section of the profile a LocalStack twin mirrors, if there is one.
+func mirroredSection(cfg *ini.File, sec *ini.Section) (*ini.Section, bool) {
+	name := keyValue(sec, mirrorOfKey)
+	if name == "" {
+		return nil, false
+	}
+	source, err := cfg.GetSection(accountSectionName(name))
+	if err != nil {
+		return nil, false
+	}
+	return source, true
+}
+
+// pruneServicesSections removes generated [services] sections which weren't written during this merge
+// and are no longer referenced by any profile.
+func pruneServicesSections(opts MergeOpts, written map[string]bool, report *MergeReport) {
+	referenced := make(map[string]bool)
+	for _, sec := range opts.Config.Sections() {
+		if name := keyValue(sec, "services"); name != "" {
+			referenced[servicesSectionPrefix+name] = true
+		}
+	}
+	for _, sec := range opts.Config.Sections() {
+		name := sec.Name()
+		if !strings.HasPrefix(name, servicesSectionPrefix) || written[name] || referenced[name] || !isOwnedSection(sec, opts.Namespace) {
+			continue
+		}
+		if isPinned(sec, opts.PinnedProfiles) {
+			report.addPinned(name)
+			continue
+		}
+		opts.Config.DeleteSection(name)
+		report.Pruned = append(report.Pruned, name)
+	}
+}
@@ -0,0 +1,230 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_LocalStack(t *testing.T) {
+	prod := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+		Region:        "eu-west-1",
+	}
+	dev := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "210987654321",
+		AccountName:   "dev",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+	withServices := `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+common_fate_format_version = 1
+
+[profile local/prod/DevRole]
+aws_access_key_id          = test
+aws_secret_access_key      = test
+region                     = eu-west-1
+services                   = localstack
+common_fate_generated_from = aws-sso
+common_fate_mirror_of      = prod/DevRole
+common_fate_format_version = 1
+common_fate_generated_keys = aws_access_key_id,aws_secret_access_key,services
+
+[services localstack]
+common_fate_generated_from = localstack
+dynamodb                   = 
+  endpoint_url = http://localhost:4566
+s3                         = 
+  endpoint_url = http://localhost:4566
+common_fate_format_version = 1
+common_fate_generated_keys = dynamodb,s3
+`
+
+	tests := []struct {
+		name       string
+		config     string
+		profiles   []SSOProfile
+		localStack *LocalStack
+		policy     ConflictPolicy
+		want       string
+		wantPruned []string
+		wantErr    bool
+	}{
+		{
+			name:       "twins refer to a services section",
+			profiles:   []SSOProfile{prod},
+			localStack: &LocalStack{Services: []string{"s3", "dynamodb"}},
+			want:       withServices,
+		},
+		{
+			name:       "twins set the endpoint without services",
+			profiles:   []SSOProfile{dev},
+			localStack: &LocalStack{NamePrefix: "ls-", EndpointURL: "http://localstack:4566"},
+			want: `
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+common_fate_format_version = 1
+
+[profile ls-dev/DevRole]
+aws_access_key_id          = test
+aws_secret_access_key      = test
+region                     = us-east-1
+endpoint_url               = http://localstack:4566
+common_fate_generated_from = aws-sso
+common_fate_mirror_of      = dev/DevRole
+common_fate_format_version = 1
+common_fate_generated_keys = aws_access_key_id,aws_secret_access_key,endpoint_url
+`,
+		},
+		{
+			name:       "twins and services are pruned with their profiles",
+			config:     withServices,
+			profiles:   []SSOProfile{dev},
+			localStack: &LocalStack{Services: []string{"s3", "dynamodb"}},
+			want: `
+[services localstack]
+common_fate_generated_from = localstack
+dynamodb                   = 
+  endpoint_url = http://localhost:4566
+s3                         = 
+  endpoint_url = http://localhost:4566
+common_fate_format_version = 1
+common_fate_generated_keys = dynamodb,s3
+
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile dev/DevRole
+common_fate_format_version = 1
+
+[profile local/dev/DevRole]
+aws_access_key_id          = test
+aws_secret_access_key      = test
+region                     = us-east-1
+services                   = localstack
+common_fate_generated_from = aws-sso
+common_fate_mirror_of      = dev/DevRole
+common_fate_format_version = 1
+common_fate_generated_keys = aws_access_key_id,aws_secret_access_key,services
+`,
+			wantPruned: []string{"profile prod/DevRole", "profile local/prod/DevRole"},
+		},
+		{
+			name:     "twins and services are pruned when LocalStack is turned off",
+			config:   withServices,
+			profiles: []SSOProfile{prod},
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+common_fate_format_version = 1
+`,
+			wantPruned: []string{"profile local/prod/DevRole", "services localstack"},
+		},
+		{
+			name: "hand-written services sections are left alone",
+			config: `
+[services localstack]
+s3 =
+  endpoint_url = http://localhost:4567
+`,
+			profiles:   []SSOProfile{prod},
+			localStack: &LocalStack{Services: []string{"s3"}},
+			want: `
+[services localstack]
+s3 = 
+  endpoint_url = http://localhost:4567
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+common_fate_format_version = 1
+
+[profile local/prod/DevRole]
+aws_access_key_id          = test
+aws_secret_access_key      = test
+region                     = eu-west-1
+services                   = localstack
+common_fate_generated_from = aws-sso
+common_fate_mirror_of      = prod/DevRole
+common_fate_format_version = 1
+common_fate_generated_keys = aws_access_key_id,aws_secret_access_key,services
+`,
+		},
+		{
+			name: "the conflict policy applies to twins",
+			config: `
+[profile local/prod/DevRole]
+region = us-west-2
+`,
+			profiles:   []SSOProfile{prod},
+			localStack: &LocalStack{},
+			policy:     ConflictSkip,
+			want: `
+[profile local/prod/DevRole]
+region = us-west-2
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:       "invalid endpoint",
+			profiles:   []SSOProfile{prod},
+			localStack: &LocalStack{EndpointURL: "localhost:4566"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseNestedIni(t, tt.config)
+			report, err := MergeWithReport(MergeOpts{
+				Config:         cfg,
+				Profiles:       tt.profiles,
+				LocalStack:     tt.localStack,
+				ConflictPolicy: tt.policy,
+				PruneStartURLs: []string{"https://example.awsapps.com/start"},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+			assert.Equal(t, tt.wantPruned, report.Pruned)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package awsconfigfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/common-fate/clio"
+	"gopkg.in/ini.v1"
+)
+
+// CurrentFormatVersion is the version of the generated section format written by this library.
+// Sections written by older versions are upgraded in place by Merge.
+const CurrentFormatVersion = 1
+
+const (
+	// formatVersionKey records the format version a generated section was written with.
+	// Sections without it were written before versioning was introduced, and are version 0.
+	formatVersionKey = "common_fate_format_version"
+	// contentHashKey records a hash of the generated values in a section, when enabled with RecordContentHash.
+	contentHashKey = "common_fate_content_hash"
+	// generatedAtKey records when the generated values in a section last changed, when enabled with RecordGenerationTime.
+	generatedAtKey = "common_fate_generated_at"
+)
+
+// metadataKeys are the keys which describe a generated section, rather than being part of its content.
+var metadataKeys = map[string]bool{
+	contentHashKey:   true,
+	formatVersionKey: true,
+	generatedAtKey:   true,
+	generatedKeysKey: true,
+	namespaceKey:     true,
+	staleSinceKey:    true,
+}
+
+// sectionMetadata is the metadata written to each generated section during a merge.
+type sectionMetadata struct {
+	namespace   string
+	contentHash bool
+	// generatedAt is when the merge ran. It is zero if the generation time isn't recorded.
+	generatedAt time.Time
+}
+
+func newSectionMetadata(opts MergeOpts) sectionMetadata {
+	m := sectionMetadata{namespace: opts.Namespace, contentHash: opts.RecordContentHash}
+	if opts.RecordGenerationTime {
+		m.generatedAt = opts.currentTime()
+	}
+	return m
+}
+
+// add writes the metadata keys to a rendered section, before it is applied to the existing section.
+// existing is nil if the section doesn't exist yet.
+func (m sectionMetadata) add(generated *ini.Section, existing *ini.Section) error {
+	hash := contentHash(generated, nil)
+
+	if existing != nil && existing.HasKey(contentHashKey) {
+		if previous := contentHash(existing, ownedKeys(existing)); previous != keyValue(existing, contentHashKey) {
+			clio.Warnf("Generated values in %s were edited by hand and will be overwritten", existing.Name())
+		}
+	}
+
+	values := [][2]string{}
+	if m.namespace != "" {
+		values = append(values, [2]string{namespaceKey, m.namespace})
+	}
+	values = append(values, [2]string{formatVersionKey, strconv.Itoa(CurrentFormatVersion)})
+	if m.contentHash {
+		values = append(values, [2]string{contentHashKey, hash})
+	}
+	if !m.generatedAt.IsZero() {
+		generatedAt := m.generatedAt.Format(time.RFC3339)
+		// only move the generation time forwards when the generated values change
+		if existing != nil && existing.HasKey(generatedAtKey) && contentHash(existing, ownedKeys(existing)) == hash {
+			generatedAt = keyValue(existing, generatedAtKey)
+		}
+		values = append(values, [2]string{generatedAtKey, generatedAt})
+	}
+
+	for _, v := range values {
+		_, err := generated.NewKey(v[0], v[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contentHash returns a hash of the values in the section, ignoring metadata keys.
+// If keys is not nil, only the keys in it are included.
+func contentHash(sec *ini.Section, keys map[string]bool) string {
+	var lines []string
+	for _, k := range sec.Keys() {
+		if metadataKeys[k.Name()] || (keys != nil && !keys[k.Name()]) {
+			continue
+		}
+		line := k.Name() + "=" + k.Value()
+		for _, v := range k.NestedValues() {
+			line += "\n  " + v
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// formatVersion returns the format version a generated section was written with.
+func formatVersion(sec *ini.Section) (int, error) {
+	v := keyValue(sec, formatVersionKey)
+	if v == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q in [%s]", formatVersionKey, v, sec.Name())
+	}
+	return version, nil
+}
+
+// migration upgrades a generated section to a newer format version.
+type migration struct {
+	// version is the format version the migration upgrades sections to.
+	version     int
+	description string
+	// apply updates the section in place. It may be nil if only the version changes.
+	apply func(sec *ini.Section) error
+}
+
+// formatMigrations are the migrations between each format version, in order.
+var formatMigrations = []migration{
+	{
+		version:     1,
+		description: "record the format version",
+	},
+}
+
+// MigratedSection records a generated section which was upgraded to the current format.
+type MigratedSection struct {
+	SectionName string
+	FromVersion int
+	ToVersion   int
+	// Migrations describes each of the migrations which were applied, in order.
+	Migrations []string
+}
+
+// migrateSections upgrades the generated sections in the namespace which were written
+// with an older format version. Pinned sections are left as they are.
+func migrateSections(opts MergeOpts, report *MergeReport) error {
+	migrations := formatMigrations
+	if opts.migrations != nil {
+		migrations = opts.migrations
+	}
+	latest := 0
+	for _, m := range migrations {
+		if m.version > latest {
+			latest = m.version
+		}
+	}
+
+	for _, sec := range opts.Config.Sections() {
+		if !isOwnedSection(sec, opts.Namespace) {
+			continue
+		}
+		version, err := formatVersion(sec)
+		if err != nil {
+			return err
+		}
+		if version > latest {
+			return fmt.Errorf("[%s] was generated with format version %d, which is newer than this version supports (%d)", sec.Name(), version, latest)
+		}
+		if version == latest || isPinned(sec, opts.PinnedProfiles) {
+			continue
+		}
+
+		migrated := MigratedSection{SectionName: sec.Name(), FromVersion: version, ToVersion: latest}
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			if m.apply != nil {
+				err = m.apply(sec)
+				if err != nil {
+					return fmt.Errorf("migrating [%s] to format version %d: %w", sec.Name(), m.version, err)
+				}
+			}
+			migrated.Migrations = append(migrated.Migrations, m.description)
+		}
+		_, err = sec.NewKey(formatVersionKey, strconv.Itoa(latest))
+		if err != nil {
+			return err
+		}
+		clio.Debugf("Migrated %s from format version %d to %d", sec.Name(), version, latest)
+		report.Migrated = append(report.Migrated, migrated)
+	}
+	return nil
+}
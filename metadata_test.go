@@ -0,0 +1,182 @@
+package awsconfigfile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestMerge_Migrations(t *testing.T) {
+	tests := []struct {
+		name         string
+		migrations   []migration
+		config       string
+		want         string
+		wantMigrated []MigratedSection
+		wantErr      bool
+	}{
+		{
+			name: "unversioned sections are upgraded",
+			config: `
+[profile old/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+`,
+			want: `
+[profile old/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+`,
+			wantMigrated: []MigratedSection{
+				{SectionName: "profile old/DevRole", FromVersion: 0, ToVersion: 1, Migrations: []string{"record the format version"}},
+			},
+		},
+		{
+			name: "current sections are left alone",
+			config: `
+[profile old/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+`,
+			want: `
+[profile old/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+common_fate_generated_from = aws-sso
+common_fate_format_version = 1
+`,
+		},
+		{
+			name: "migrations are applied in order",
+			migrations: []migration{
+				{version: 1, description: "record the format version"},
+				{version: 2, description: "rename sso_url", apply: renameKeyMigration("sso_url", "granted_sso_start_url")},
+			},
+			config: `
+[profile old/DevRole]
+sso_url                    = https://example.awsapps.com/start
+common_fate_generated_from = aws-sso
+`,
+			want: `
+[profile old/DevRole]
+common_fate_generated_from = aws-sso
+granted_sso_start_url      = https://example.awsapps.com/start
+common_fate_format_version = 2
+`,
+			wantMigrated: []MigratedSection{
+				{SectionName: "profile old/DevRole", FromVersion: 0, ToVersion: 2, Migrations: []string{"record the format version", "rename sso_url"}},
+			},
+		},
+		{
+			name: "newer versions are an error",
+			config: `
+[profile new/DevRole]
+common_fate_generated_from = aws-sso
+common_fate_format_version = 99
+`,
+			wantErr: true,
+		},
+		{
+			name: "hand-written sections are not migrated",
+			config: `
+[profile manual]
+region = us-east-1
+`,
+			want: `
+[profile manual]
+region = us-east-1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			report, err := MergeWithReport(MergeOpts{
+				Config:     cfg,
+				migrations: tt.migrations,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+			assert.Equal(t, tt.wantMigrated, report.Migrated)
+		})
+	}
+}
+
+func TestMerge_GenerationMetadata(t *testing.T) {
+	profile := func(region string) []SSOProfile {
+		return []SSOProfile{&AccountProfile{
+			SSOStartURL:   "https://example.awsapps.com/start",
+			AccountID:     "123456789012",
+			AccountName:   "prod",
+			RoleName:      "DevRole",
+			GeneratedFrom: "aws-sso",
+			Region:        region,
+		}}
+	}
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	merge := func(cfg *ini.File, at time.Time, profiles []SSOProfile) {
+		t.Helper()
+		err := Merge(MergeOpts{
+			Config:               cfg,
+			Profiles:             profiles,
+			RecordContentHash:    true,
+			RecordGenerationTime: true,
+			now:                  func() time.Time { return at },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := parseIni(t, "")
+	merge(cfg, first, profile("us-east-1"))
+	sec := cfg.Section("profile prod/DevRole")
+	hash := sec.Key(contentHashKey).String()
+	assert.Len(t, hash, 16)
+	assert.Equal(t, "2024-01-02T03:04:05Z", sec.Key(generatedAtKey).String())
+
+	// the generation time doesn't change if the generated values are the same
+	merge(cfg, first.Add(time.Hour), profile("us-east-1"))
+	assert.Equal(t, hash, sec.Key(contentHashKey).String())
+	assert.Equal(t, "2024-01-02T03:04:05Z", sec.Key(generatedAtKey).String())
+
+	// hand-added keys don't change the hash
+	_, err := sec.NewKey("output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge(cfg, first.Add(2*time.Hour), profile("us-east-1"))
+	assert.Equal(t, hash, sec.Key(contentHashKey).String())
+	assert.Equal(t, "2024-01-02T03:04:05Z", sec.Key(generatedAtKey).String())
+
+	merge(cfg, first.Add(3*time.Hour), profile("us-west-2"))
+	assert.NotEqual(t, hash, sec.Key(contentHashKey).String())
+	assert.Equal(t, "2024-01-02T06:04:05Z", sec.Key(generatedAtKey).String())
+}
+
+// renameKeyMigration returns a migration function which renames a key, keeping its value.
+func renameKeyMigration(from, to string) func(sec *ini.Section) error {
+	return func(sec *ini.Section) error {
+		if !sec.HasKey(from) {
+			return nil
+		}
+		_, err := sec.NewKey(to, sec.Key(from).String())
+		if err != nil {
+			return err
+		}
+		sec.DeleteKey(from)
+		return nil
+	}
+}
@@ -0,0 +1,251 @@
+package awsconfigfile
+
+import (
+	"testing"
+)
+
+func TestMerge_Namespace(t *testing.T) {
+	profile := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		policy    ConflictPolicy
+		config    string
+		want      string
+	}{
+		{
+			name:      "namespace is written",
+			namespace: "platform",
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_namespace      = platform
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:      "other namespaces are not pruned",
+			namespace: "platform",
+			config: `
+[profile team/Deploy]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = Deploy
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+
+[profile legacy/Deploy]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = Deploy
+common_fate_generated_from = aws-sso
+`,
+			want: `
+[profile team/Deploy]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = Deploy
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+
+[profile legacy/Deploy]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = Deploy
+common_fate_generated_from = aws-sso
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_namespace      = platform
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:      "own namespace is pruned",
+			namespace: "team",
+			config: `
+[profile team/Deploy]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = Deploy
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+`,
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_namespace      = team
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:      "other namespaces are treated as hand-written",
+			namespace: "platform",
+			policy:    ConflictSkip,
+			config: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = TeamRole
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+`,
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = TeamRole
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+`,
+		},
+		{
+			name:      "other namespaces are never overwritten",
+			namespace: "platform",
+			config: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = TeamRole
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+`,
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = TeamRole
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+`,
+		},
+		{
+			name:      "other namespaces can be renamed around",
+			namespace: "platform",
+			policy:    ConflictRename,
+			config: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = TeamRole
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+`,
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = TeamRole
+common_fate_generated_from = aws-sso
+common_fate_namespace      = team
+
+[profile prod/DevRole-generated]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole-generated
+common_fate_namespace      = platform
+common_fate_format_version = 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			p := *profile
+			err := Merge(MergeOpts{
+				Config:         cfg,
+				Profiles:       []SSOProfile{&p},
+				Namespace:      tt.namespace,
+				ConflictPolicy: tt.policy,
+				PruneStartURLs: []string{"https://example.awsapps.com/start"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
+
+func TestMerge_NamespacesShareSSOSession(t *testing.T) {
+	cfg := parseIni(t, "")
+	for _, ns := range []struct {
+		namespace string
+		account   string
+	}{
+		{namespace: "platform", account: "prod"},
+		{namespace: "team", account: "dev"},
+	} {
+		err := Merge(MergeOpts{
+			Config: cfg,
+			Profiles: []SSOProfile{&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				SSORegion:     "us-east-1",
+				AccountID:     "123456789012",
+				AccountName:   ns.account,
+				RoleName:      "DevRole",
+				GeneratedFrom: "aws-sso",
+				SourceID:      ns.namespace,
+			}},
+			Namespace:           ns.namespace,
+			SessionName:         "corp",
+			NoCredentialProcess: true,
+			PruneSourceIDs:      []string{ns.namespace},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the session is kept while the other namespace uses it, even after its own profiles are pruned
+	err := Merge(MergeOpts{
+		Config:              cfg,
+		Namespace:           "platform",
+		SessionName:         "corp",
+		NoCredentialProcess: true,
+		PruneSourceIDs:      []string{"platform"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, `
+[sso-session corp]
+sso_start_url              = https://example.awsapps.com/start
+sso_registration_scopes    = 
+sso_region                 = us-east-1
+common_fate_generated_from = aws-sso
+common_fate_source         = platform
+common_fate_namespace      = platform
+common_fate_format_version = 1
+
+[profile dev/DevRole]
+sso_session                = corp
+sso_account_id             = 123456789012
+common_fate_generated_from = aws-sso
+common_fate_source         = team
+sso_role_name              = DevRole
+common_fate_namespace      = team
+common_fate_format_version = 1
+`)
+}
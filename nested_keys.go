@@ -0,0 +1,181 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/common-fate/clio"
+	"gopkg.in/ini.v1"
+)
+
+// LoadOptions are the options an AWS config file should be loaded with before it is merged.
+// They keep nested values, such as
+//
+//	s3 =
+//	  max_concurrent_requests = 20
+//
+// intact. Without them the nested settings are read as ordinary keys,
+// and are written back without their indentation.
+var LoadOptions = ini.LoadOptions{AllowNestedValues: true}
+
+// knownNestedKeys are the AWS config file settings which take nested values.
+var knownNestedKeys = map[string]bool{
+	"s3":    true,
+	"s3api": true,
+}
+
+// nestedValuesProbeKey is the throwaway key used to check whether a config allows nested values.
+const nestedValuesProbeKey = "common_fate_nested_values_probe"
+
+// allowsNestedValues returns true if the config was loaded with AllowNestedValues.
+// ini doesn't expose the options a file was loaded with, so a nested value is added to a throwaway key.
+func allowsNestedValues(cfg *ini.File) bool {
+	sec := cfg.Section(ini.DefaultSection)
+	key, err := sec.NewKey(nestedValuesProbeKey, "")
+	if err != nil {
+		return false
+	}
+	defer sec.DeleteKey(nestedValuesProbeKey)
+	return key.AddNestedValue("probe") == nil
+}
+
+// checkNestedValues returns an error if the config wasn't loaded with LoadOptions
+// and the merge would write nested values, or would write back a nested block which was flattened when it was loaded.
+// It is called before anything is written, so that the config is never left half merged.
+func checkNestedValues(cfg *ini.File, needed bool) error {
+	if allowsNestedValues(cfg) {
+		return nil
+	}
+	if needed {
+		return fmt.Errorf("nested keys can only be written to a config loaded with awsconfigfile.LoadOptions")
+	}
+	for _, sec := range cfg.Sections() {
+		for _, k := range sec.Keys() {
+			// every key in a [services] section is a block of nested settings
+			nested := knownNestedKeys[k.Name()] || strings.HasPrefix(sec.Name(), servicesSectionPrefix)
+			if nested && k.Value() == "" {
+				return fmt.Errorf("the %s settings in [%s] would be flattened, as the config wasn't loaded with awsconfigfile.LoadOptions", k.Name(), sec.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// nestedKeysEntry is implemented by ini entries which write nested keys in addition to their fields.
+type nestedKeysEntry interface {
+	nestedKeys() map[string]map[string]string
+}
+
+// validateNestedKeys returns an error if any of the nested keys would replace a generated key
+// or one of the extra keys, and logs a warning for keys which aren't known to take nested values.
+func validateNestedKeys(nested map[string]map[string]string, extra map[string]string) error {
+	for k, settings := range nested {
+		if !validKeyName(k) {
+			return fmt.Errorf("invalid nested key %q", k)
+		}
+		if builtinGeneratedKeys[k] {
+			return fmt.Errorf("nested key %s can't be used as it is generated", k)
+		}
+		if _, ok := extra[k]; ok {
+			return fmt.Errorf("%s is set as both an extra key and a nested key", k)
+		}
+		if len(settings) == 0 {
+			return fmt.Errorf("nested key %s has no settings", k)
+		}
+		for name, v := range settings {
+			if !validKeyName(name) {
+				return fmt.Errorf("invalid nested setting %q in %s", name, k)
+			}
+			if strings.ContainsAny(v, "\r\n") {
+				return fmt.Errorf("nested setting %s.%s must not contain a newline", k, name)
+			}
+		}
+		if !knownNestedKeys[k] {
+			clio.Warnf("Nested key %s is not a known AWS config setting", k)
+		}
+	}
+	return nil
+}
+
+// mergeNestedKeys returns the default nested keys overlaid with the profile's own.
+// The settings of a nested key are combined, with the profile's taking precedence.
+func mergeNestedKeys(defaults map[string]map[string]string, nested map[string]map[string]string) map[string]map[string]string {
+	if len(defaults) == 0 && len(nested) == 0 {
+		return nil
+	}
+	merged := make(map[string]map[string]string, len(defaults)+len(nested))
+	for _, m := range []map[string]map[string]string{defaults, nested} {
+		for k, settings := range m {
+			if merged[k] == nil {
+				merged[k] = make(map[string]string, len(settings))
+			}
+			for name, v := range settings {
+				merged[k][name] = v
+			}
+		}
+	}
+	return merged
+}
+
+// writeNestedKeys adds the nested keys to a rendered section, in sorted order.
+func writeNestedKeys(sec *ini.Section, nested map[string]map[string]string) error {
+	keys := make([]string, 0, len(nested))
+	for k := range nested {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key, err := sec.NewKey(k, "")
+		if err != nil {
+			return err
+		}
+		for _, v := range nestedValues(nested[k]) {
+			err = key.AddNestedValue(v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nestedValues returns the settings as sorted "name = value" lines.
+func nestedValues(settings map[string]string) []string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = name + " = " + settings[name]
+	}
+	return values
+}
+
+// copyKey sets a key in the section to the value and nested values of k.
+// An existing key whose nested values differ is replaced, as ini can't remove nested values.
+func copyKey(sec *ini.Section, k *ini.Key) error {
+	if sec.HasKey(k.Name()) {
+		existing := sec.Key(k.Name())
+		if existing.Value() == k.Value() && slices.Equal(existing.NestedValues(), k.NestedValues()) {
+			return nil
+		}
+		if len(existing.NestedValues()) > 0 || len(k.NestedValues()) > 0 {
+			sec.DeleteKey(k.Name())
+		}
+	}
+	key, err := sec.NewKey(k.Name(), k.Value())
+	if err != nil {
+		return err
+	}
+	for _, v := range k.NestedValues() {
+		err = key.AddNestedValue(v)
+		if err != nil {
+			return fmt.Errorf("writing nested key %s to [%s]: %w (the config must be loaded with awsconfigfile.LoadOptions)", k.Name(), sec.Name(), err)
+		}
+	}
+	return nil
+}
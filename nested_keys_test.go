@@ -0,0 +1,255 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+// parseNestedIni loads a config with LoadOptions, as nested settings are only kept with them.
+func parseNestedIni(t *testing.T, data string) *ini.File {
+	cfg, err := ini.LoadSources(LoadOptions, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestMerge_NestedKeys(t *testing.T) {
+	generated := `
+[profile manual]
+region = us-east-1
+s3     = 
+  max_concurrent_requests = 20
+  addressing_style = path
+output = json
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+s3                         = 
+  addressing_style = virtual
+  max_concurrent_requests = 10
+common_fate_format_version = 1
+common_fate_generated_keys = s3
+`
+	tests := []struct {
+		name     string
+		config   string
+		nested   map[string]map[string]string
+		defaults map[string]map[string]string
+		extra    map[string]string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "nested settings are generated and hand-written blocks are kept",
+			config: `
+[profile manual]
+region = us-east-1
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+output = json
+`,
+			defaults: map[string]map[string]string{"s3": {"addressing_style": "virtual", "max_concurrent_requests": "5"}},
+			nested:   map[string]map[string]string{"s3": {"max_concurrent_requests": "10"}},
+			want:     generated,
+		},
+		{
+			name:   "generated nested settings are unchanged on a second merge",
+			config: generated,
+			nested: map[string]map[string]string{"s3": {"addressing_style": "virtual", "max_concurrent_requests": "10"}},
+			want:   generated,
+		},
+		{
+			name:   "changed nested settings are replaced",
+			config: generated,
+			nested: map[string]map[string]string{"s3": {"max_bandwidth": "50MB/s"}},
+			want: `
+[profile manual]
+region = us-east-1
+s3     = 
+  max_concurrent_requests = 20
+  addressing_style = path
+output = json
+
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
+s3                         = 
+  max_bandwidth = 50MB/s
+common_fate_generated_keys = s3
+`,
+		},
+		{
+			name: "hand-written nested blocks in generated sections are kept",
+			config: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+s3api =
+  endpoint_url = http://localhost:9000
+common_fate_format_version = 1
+`,
+			want: `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+s3api                      = 
+  endpoint_url = http://localhost:9000
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:    "generated keys can't be nested",
+			nested:  map[string]map[string]string{"region": {"name": "us-east-1"}},
+			wantErr: true,
+		},
+		{
+			name:    "nested keys can't also be extra keys",
+			nested:  map[string]map[string]string{"s3": {"max_concurrent_requests": "10"}},
+			extra:   map[string]string{"s3": "fast"},
+			wantErr: true,
+		},
+		{
+			name:     "nested keys need settings",
+			defaults: map[string]map[string]string{"s3": {}},
+			wantErr:  true,
+		},
+		{
+			name:    "nested settings can't contain newlines",
+			nested:  map[string]map[string]string{"s3": {"addressing_style": "path\nregion = eu-west-1"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseNestedIni(t, tt.config)
+			err := Merge(MergeOpts{
+				Config: cfg,
+				Profiles: []SSOProfile{&AccountProfile{
+					SSOStartURL:   "https://example.awsapps.com/start",
+					AccountID:     "123456789012",
+					AccountName:   "prod",
+					RoleName:      "DevRole",
+					GeneratedFrom: "aws-sso",
+					Extra:         tt.extra,
+					Nested:        tt.nested,
+				}},
+				NestedKeys: tt.defaults,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
+
+func TestMerge_NestedKeysRequireLoadOptions(t *testing.T) {
+	existing := `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
+`
+	tests := []struct {
+		name       string
+		config     string
+		nested     map[string]map[string]string
+		defaults   map[string]map[string]string
+		localStack *LocalStack
+		wantErr    string
+	}{
+		{
+			name:    "profile nested keys",
+			config:  existing,
+			nested:  map[string]map[string]string{"s3": {"max_concurrent_requests": "10"}},
+			wantErr: "awsconfigfile.LoadOptions",
+		},
+		{
+			name:     "default nested keys",
+			config:   existing,
+			defaults: map[string]map[string]string{"s3": {"max_concurrent_requests": "10"}},
+			wantErr:  "awsconfigfile.LoadOptions",
+		},
+		{
+			name:       "LocalStack services",
+			config:     existing,
+			localStack: &LocalStack{Services: []string{"s3"}},
+			wantErr:    "awsconfigfile.LoadOptions",
+		},
+		{
+			name: "flattened hand-written blocks",
+			config: existing + `
+[profile manual]
+s3 =
+  max_concurrent_requests = 20
+`,
+			wantErr: "the s3 settings in [profile manual] would be flattened",
+		},
+		{
+			name:   "no nested values",
+			config: existing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			err := Merge(MergeOpts{
+				Config: cfg,
+				Profiles: []SSOProfile{&AccountProfile{
+					SSOStartURL:   "https://example.awsapps.com/start",
+					AccountID:     "123456789012",
+					AccountName:   "prod",
+					RoleName:      "DevRole",
+					GeneratedFrom: "aws-sso",
+					Nested:        tt.nested,
+				}},
+				NestedKeys: tt.defaults,
+				LocalStack: tt.localStack,
+			})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			// nothing is written when the config can't hold the nested values
+			assert.Equal(t, writeIni(t, parseIni(t, tt.config)), writeIni(t, cfg))
+		})
+	}
+}
+
+func TestMergeCopy_KeepsNestedValues(t *testing.T) {
+	cfg := parseNestedIni(t, `
+[profile manual]
+s3 =
+  max_concurrent_requests = 20
+`)
+	got, _, err := MergeCopy(MergeOpts{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"max_concurrent_requests = 20"}, got.Section("profile manual").Key("s3").NestedValues())
+}
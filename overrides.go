@@ -0,0 +1,186 @@
+package awsconfigfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ProfileOverride is a permanent exception for a generated profile.
+// Overrides are applied on top of the generated values every time Merge runs,
+// so they aren't lost in the same way as hand edits to generated sections.
+type ProfileOverride struct {
+	// AccountID is the AWS account the override applies to.
+	AccountID string `json:"account_id"`
+	// RoleName restricts the override to a single role.
+	// If empty, the override applies to every role in the account.
+	RoleName string `json:"role_name,omitempty"`
+	// AccountName replaces the account name used when rendering the profile name.
+	AccountName string `json:"account_name,omitempty"`
+	// ProfileName replaces the rendered profile name. The profile prefix is still added to it.
+	ProfileName string `json:"profile_name,omitempty"`
+	// Set adds keys to the generated profile, replacing any generated values.
+	Set map[string]string `json:"set,omitempty"`
+	// Remove deletes keys from the generated profile.
+	Remove []string `json:"remove,omitempty"`
+	// Suppress prevents the profile from being generated.
+	Suppress bool `json:"suppress,omitempty"`
+}
+
+// Overrides is a document containing permanent per-profile overrides.
+//
+//	{
+//	  "profiles": [
+//	    {"account_id": "123456789012", "set": {"region": "eu-west-1"}},
+//	    {"account_id": "123456789012", "role_name": "Admin", "suppress": true}
+//	  ]
+//	}
+type Overrides struct {
+	Profiles []ProfileOverride `json:"profiles"`
+}
+
+// LoadOverrides reads an overrides document in JSON format.
+func LoadOverrides(r io.Reader) (*Overrides, error) {
+	var o Overrides
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&o)
+	if err != nil {
+		return nil, fmt.Errorf("parsing overrides: %w", err)
+	}
+	for _, p := range o.Profiles {
+		err = p.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+// LoadOverridesFile reads an overrides document from a JSON file.
+func LoadOverridesFile(filename string) (*Overrides, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadOverrides(f)
+}
+
+func (o ProfileOverride) validate() error {
+	if o.AccountID == "" {
+		return errors.New("profile override must have an account_id")
+	}
+	if strings.ContainsAny(o.ProfileName, profileSectionIllegalChars) {
+		return fmt.Errorf("profile override for %s: profile_name must not contain any of these illegal characters (%s)", o, profileSectionIllegalChars)
+	}
+	for k, v := range o.Set {
+		if !validKeyName(k) {
+			return fmt.Errorf("profile override for %s: invalid key %q", o, k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("profile override for %s: value of %s must not contain a newline", o, k)
+		}
+	}
+	return nil
+}
+
+func (o ProfileOverride) String() string {
+	if o.RoleName == "" {
+		return o.AccountID
+	}
+	return o.AccountID + "/" + o.RoleName
+}
+
+// apply the keys set or removed by the override to a rendered section.
+func (o ProfileOverride) apply(sec *ini.Section) error {
+	for _, k := range o.Remove {
+		sec.DeleteKey(k)
+	}
+
+	keys := make([]string, 0, len(o.Set))
+	for k := range o.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, err := sec.NewKey(k, o.Set[k])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overrideSet matches overrides to profiles and tracks which ones have been used.
+type overrideSet struct {
+	overrides []ProfileOverride
+	used      []bool
+}
+
+func newOverrideSet(overrides []ProfileOverride) (*overrideSet, error) {
+	for _, o := range overrides {
+		err := o.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &overrideSet{overrides: overrides, used: make([]bool, len(overrides))}, nil
+}
+
+// match returns the combined override for an account and role.
+// Overrides for the whole account are applied first, so that
+// overrides for a specific role take precedence over them.
+func (s *overrideSet) match(accountID, roleName string) (ProfileOverride, bool) {
+	combined := ProfileOverride{AccountID: accountID, RoleName: roleName}
+	var found bool
+
+	for _, roleSpecific := range []bool{false, true} {
+		for i, o := range s.overrides {
+			if o.AccountID != accountID || (o.RoleName != "") != roleSpecific {
+				continue
+			}
+			if roleSpecific && o.RoleName != roleName {
+				continue
+			}
+			s.used[i] = true
+			found = true
+
+			if o.AccountName != "" {
+				combined.AccountName = o.AccountName
+			}
+			if o.ProfileName != "" {
+				combined.ProfileName = o.ProfileName
+			}
+			for _, k := range o.Remove {
+				delete(combined.Set, k)
+				combined.Remove = append(combined.Remove, k)
+			}
+			for k, v := range o.Set {
+				if combined.Set == nil {
+					combined.Set = make(map[string]string)
+				}
+				combined.Set[k] = v
+			}
+			combined.Suppress = combined.Suppress || o.Suppress
+		}
+	}
+	return combined, found
+}
+
+// unused returns the overrides which didn't match any generated profile.
+func (s *overrideSet) unused() []ProfileOverride {
+	var unused []ProfileOverride
+	for i, o := range s.overrides {
+		if !s.used[i] {
+			unused = append(unused, o)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,151 @@
+package awsconfigfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    *Overrides
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			doc: `{"profiles": [
+				{"account_id": "123456789012", "set": {"region": "eu-west-1"}},
+				{"account_id": "123456789012", "role_name": "Admin", "suppress": true}
+			]}`,
+			want: &Overrides{Profiles: []ProfileOverride{
+				{AccountID: "123456789012", Set: map[string]string{"region": "eu-west-1"}},
+				{AccountID: "123456789012", RoleName: "Admin", Suppress: true},
+			}},
+		},
+		{
+			name:    "missing account id",
+			doc:     `{"profiles": [{"role_name": "Admin"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "illegal profile name",
+			doc:     `{"profiles": [{"account_id": "123456789012", "profile_name": "my prod]x"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid key name",
+			doc:     `{"profiles": [{"account_id": "123456789012", "set": {"region = x": "eu-west-1"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "value with a newline",
+			doc:     `{"profiles": [{"account_id": "123456789012", "set": {"region": "eu-west-1\n[profile evil]"}}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			doc:     `{"profiles": [{"account_id": "123456789012", "regoin": "eu-west-1"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadOverrides(strings.NewReader(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMerge_Overrides(t *testing.T) {
+	cfg := parseIni(t, `
+[profile prod/Admin]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = Admin
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/Admin
+`)
+	profiles := []SSOProfile{
+		&AccountProfile{
+			SSOStartURL:   "https://example.awsapps.com/start",
+			SSORegion:     "ap-southeast-2",
+			AccountID:     "123456789012",
+			AccountName:   "prod",
+			RoleName:      "Admin",
+			GeneratedFrom: "aws-sso",
+		},
+		&AccountProfile{
+			SSOStartURL:   "https://example.awsapps.com/start",
+			SSORegion:     "ap-southeast-2",
+			AccountID:     "123456789012",
+			AccountName:   "prod",
+			RoleName:      "DevRole",
+			GeneratedFrom: "aws-sso",
+			Region:        "us-east-1",
+		},
+		&AccountProfile{
+			SSOStartURL:   "https://example.awsapps.com/start",
+			SSORegion:     "ap-southeast-2",
+			AccountID:     "210987654321",
+			AccountName:   "sandbox account",
+			RoleName:      "DevRole",
+			GeneratedFrom: "aws-sso",
+			Region:        "us-east-1",
+		},
+	}
+	overrides := []ProfileOverride{
+		{AccountID: "123456789012", Set: map[string]string{"region": "eu-west-1", "output": "json"}},
+		{AccountID: "123456789012", RoleName: "DevRole", Remove: []string{"output"}},
+		{AccountID: "123456789012", RoleName: "Admin", Suppress: true},
+		{AccountID: "210987654321", AccountName: "sandbox", Remove: []string{"region"}},
+		{AccountID: "999999999999", ProfileName: "gone"},
+	}
+
+	report, err := MergeWithReport(MergeOpts{
+		Config:    cfg,
+		Profiles:  profiles,
+		Overrides: overrides,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIni(t, cfg, `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+region                     = eu-west-1
+common_fate_format_version = 1
+
+[profile sandbox/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 210987654321
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile sandbox/DevRole
+common_fate_format_version = 1
+`)
+	assert.Equal(t, []string{"prod/Admin"}, report.Suppressed)
+	assert.Equal(t, []ProfileOverride{{AccountID: "999999999999", ProfileName: "gone"}}, report.UnusedOverrides)
+}
+
+func TestMerge_InvalidOverride(t *testing.T) {
+	err := Merge(MergeOpts{
+		Config:              parseIni(t, ""),
+		NoCredentialProcess: true,
+		Overrides:           []ProfileOverride{{AccountID: "123456789012", ProfileName: "my prod]x"}},
+	})
+	assert.ErrorContains(t, err, "illegal characters")
+}
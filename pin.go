@@ -0,0 +1,89 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// pinnedKey marks a section as pinned. Merge never updates or prunes pinned sections,
+// even if they contain 'common_fate_generated_from'.
+const pinnedKey = "common_fate_pinned"
+
+// isPinned returns true if the section has been pinned, either with the pinned
+// marker key or because it matches one of the provided selectors.
+func isPinned(sec *ini.Section, selectors []string) bool {
+	if sec.HasKey(pinnedKey) && sec.Key(pinnedKey).MustBool(false) {
+		return true
+	}
+	for _, selector := range selectors {
+		if matchesProfileSelector(sec, selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPinnedSection returns true if the config contains the named section and it is pinned.
+func isPinnedSection(cfg *ini.File, sectionName string, selectors []string) bool {
+	sec, err := cfg.GetSection(sectionName)
+	if err != nil {
+		return false
+	}
+	return isPinned(sec, selectors)
+}
+
+// matchesProfileSelector returns true if the selector is the name of the profile
+// or the AWS account ID which the profile provides access to.
+func matchesProfileSelector(sec *ini.Section, selector string) bool {
+	if sec.Name() == selector || sec.Name() == "profile "+selector {
+		return true
+	}
+	for _, key := range []string{"granted_sso_account_id", "sso_account_id"} {
+		if sec.HasKey(key) && sec.Key(key).String() == selector {
+			return true
+		}
+	}
+	return false
+}
+
+// PinProfiles marks the profiles matching the selectors as pinned, so that Merge
+// doesn't update or prune them. A selector is either a profile name or an AWS account ID.
+// It returns the names of the sections which were pinned.
+func PinProfiles(cfg *ini.File, selectors ...string) ([]string, error) {
+	return setPinned(cfg, selectors, true)
+}
+
+// UnpinProfiles removes the pinned marker from the profiles matching the selectors,
+// so that Merge manages them again. A selector is either a profile name or an AWS account ID.
+// It returns the names of the sections which were unpinned.
+func UnpinProfiles(cfg *ini.File, selectors ...string) ([]string, error) {
+	return setPinned(cfg, selectors, false)
+}
+
+func setPinned(cfg *ini.File, selectors []string, pinned bool) ([]string, error) {
+	var changed []string
+	for _, selector := range selectors {
+		var found bool
+		for _, sec := range cfg.Sections() {
+			if !strings.HasPrefix(sec.Name(), "profile ") || !matchesProfileSelector(sec, selector) {
+				continue
+			}
+			found = true
+			if pinned {
+				_, err := sec.NewKey(pinnedKey, "true")
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				sec.DeleteKey(pinnedKey)
+			}
+			changed = append(changed, sec.Name())
+		}
+		if !found {
+			return nil, fmt.Errorf("no profiles match %s", selector)
+		}
+	}
+	return changed, nil
+}
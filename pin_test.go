@@ -0,0 +1,104 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinProfiles(t *testing.T) {
+	cfg := parseIni(t, `
+[profile prod/DevRole]
+granted_sso_account_id     = 123456789012
+common_fate_generated_from = aws-sso
+
+[profile prod/Admin]
+granted_sso_account_id     = 123456789012
+common_fate_generated_from = aws-sso
+
+[profile dev/DevRole]
+granted_sso_account_id     = 210987654321
+common_fate_generated_from = aws-sso
+`)
+
+	pinned, err := PinProfiles(cfg, "123456789012", "dev/DevRole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"profile prod/DevRole", "profile prod/Admin", "profile dev/DevRole"}, pinned)
+
+	unpinned, err := UnpinProfiles(cfg, "prod/Admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"profile prod/Admin"}, unpinned)
+
+	assertIni(t, cfg, `
+[profile prod/DevRole]
+granted_sso_account_id     = 123456789012
+common_fate_generated_from = aws-sso
+common_fate_pinned         = true
+
+[profile prod/Admin]
+granted_sso_account_id     = 123456789012
+common_fate_generated_from = aws-sso
+
+[profile dev/DevRole]
+granted_sso_account_id     = 210987654321
+common_fate_generated_from = aws-sso
+common_fate_pinned         = true
+`)
+
+	_, err = PinProfiles(cfg, "doesnotexist")
+	assert.Error(t, err)
+}
+
+func TestMerge_Pinned(t *testing.T) {
+	cfg := parseIni(t, `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = my-wrapper prod/DevRole
+common_fate_pinned         = true
+
+[profile old/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+common_fate_generated_from = aws-sso
+`)
+
+	report, err := MergeWithReport(MergeOpts{
+		Config: cfg,
+		Profiles: []SSOProfile{
+			&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				SSORegion:     "ap-southeast-2",
+				AccountID:     "123456789012",
+				AccountName:   "prod",
+				RoleName:      "DevRole",
+				GeneratedFrom: "aws-sso",
+			},
+		},
+		PruneStartURLs: []string{"https://example.awsapps.com/start"},
+		PinnedProfiles: []string{"210987654321"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertIni(t, cfg, `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = my-wrapper prod/DevRole
+common_fate_pinned         = true
+
+[profile old/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 210987654321
+common_fate_generated_from = aws-sso
+`)
+	assert.Equal(t, []string{"profile prod/DevRole", "profile old/DevRole"}, report.Pinned)
+}
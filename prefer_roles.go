@@ -0,0 +1,198 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/common-fate/clio"
+	"github.com/dlclark/regexp2"
+)
+
+// RolePreference ranks the roles whose names match Pattern.
+// When several profiles render to the same name, the ones with the lowest rank are written.
+//
+// Preferences can be scoped to particular accounts or organizational units.
+// Scoped preferences replace the unscoped ones for the accounts they apply to,
+// with account-scoped preferences taking precedence over OU-scoped ones.
+type RolePreference struct {
+	// Pattern is a regular expression matched against the role name.
+	Pattern string
+	// Rank orders the preferences. Lower ranks are preferred.
+	Rank int
+	// AccountIDs restricts the preference to the given AWS accounts.
+	AccountIDs []string
+	// OrganizationalUnits restricts the preference to accounts in the given organizational units.
+	OrganizationalUnits []string
+}
+
+func (p RolePreference) scope() string {
+	switch {
+	case len(p.AccountIDs) > 0:
+		return "account " + strings.Join(p.AccountIDs, ",")
+	case len(p.OrganizationalUnits) > 0:
+		return "OU " + strings.Join(p.OrganizationalUnits, ",")
+	}
+	return "global"
+}
+
+type compiledRolePreference struct {
+	RolePreference
+	regex *regexp2.Regexp
+}
+
+// rolePreferences are compiled and validated role preferences, grouped by scope.
+type rolePreferences struct {
+	accounts []compiledRolePreference
+	ous      []compiledRolePreference
+	global   []compiledRolePreference
+}
+
+// compileRolePreferences validates and compiles role preferences.
+// The patterns in preferRoles are converted to global preferences ranked in the order they are given.
+func compileRolePreferences(preferRoles []string, preferences []RolePreference) (*rolePreferences, error) {
+	all := make([]RolePreference, 0, len(preferRoles)+len(preferences))
+	for i, pattern := range preferRoles {
+		all = append(all, RolePreference{Pattern: pattern, Rank: i})
+	}
+	all = append(all, preferences...)
+
+	var prefs rolePreferences
+	for _, p := range all {
+		r, err := regexp2.Compile(p.Pattern, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid role preference pattern %q: %w", p.Pattern, err)
+		}
+		compiled := compiledRolePreference{RolePreference: p, regex: r}
+		switch {
+		case len(p.AccountIDs) > 0:
+			prefs.accounts = append(prefs.accounts, compiled)
+		case len(p.OrganizationalUnits) > 0:
+			prefs.ous = append(prefs.ous, compiled)
+		default:
+			prefs.global = append(prefs.global, compiled)
+		}
+	}
+	return &prefs, nil
+}
+
+// ValidateRolePreferences returns an error if any of the role preference patterns are invalid.
+func ValidateRolePreferences(preferRoles []string, preferences []RolePreference) error {
+	_, err := compileRolePreferences(preferRoles, preferences)
+	return err
+}
+
+// forAccount returns the preferences which apply to the account.
+func (p *rolePreferences) forAccount(accountID string, ou string) []compiledRolePreference {
+	var scoped []compiledRolePreference
+	for _, pref := range p.accounts {
+		if slices.Contains(pref.AccountIDs, accountID) {
+			scoped = append(scoped, pref)
+		}
+	}
+	if len(scoped) > 0 {
+		return scoped
+	}
+	for _, pref := range p.ous {
+		if ou != "" && slices.Contains(pref.OrganizationalUnits, ou) {
+			scoped = append(scoped, pref)
+		}
+	}
+	if len(scoped) > 0 {
+		return scoped
+	}
+	return p.global
+}
+
+func (p *rolePreferences) empty() bool {
+	return p == nil || len(p.accounts)+len(p.ous)+len(p.global) == 0
+}
+
+// rank returns the best ranked preference matching the profile's role.
+func (p *rolePreferences) rank(profile *AccountProfile) RankedRole {
+	ranked := RankedRole{Profile: profile.AccountID + "/" + profile.RoleName}
+	for _, pref := range p.forAccount(profile.AccountID, profile.OrganizationalUnit) {
+		ok, err := pref.regex.MatchString(profile.RoleName)
+		if err != nil {
+			clio.Debugf("Error matching role %s against %s: %s", profile.RoleName, pref.Pattern, err)
+			continue
+		}
+		if ok && (!ranked.Matched || pref.Rank < ranked.Rank) {
+			ranked.Matched = true
+			ranked.Rank = pref.Rank
+			ranked.Pattern = pref.Pattern
+			ranked.Scope = pref.scope()
+		}
+	}
+	return ranked
+}
+
+// choose returns the indexes of the profiles whose roles have the best rank,
+// and a decision explaining why they were chosen.
+func (p *rolePreferences) choose(name string, profiles []*AccountProfile) ([]int, *RoleDecision) {
+	all := make([]int, len(profiles))
+	for i := range profiles {
+		all[i] = i
+	}
+	if p.empty() {
+		return all, nil
+	}
+
+	decision := &RoleDecision{ProfileName: name}
+	var best *RankedRole
+	for _, profile := range profiles {
+		ranked := p.rank(profile)
+		decision.Roles = append(decision.Roles, ranked)
+		if ranked.Matched && (best == nil || ranked.Rank < best.Rank) {
+			r := ranked
+			best = &r
+		}
+	}
+
+	if best == nil {
+		decision.Reason = "no role preferences matched any of the roles"
+		decision.Chosen = profileIDs(profiles)
+		return all, decision
+	}
+
+	var chosen []int
+	for i, ranked := range decision.Roles {
+		if ranked.Matched && ranked.Rank == best.Rank {
+			chosen = append(chosen, i)
+			decision.Chosen = append(decision.Chosen, ranked.Profile)
+		}
+	}
+	decision.Reason = fmt.Sprintf("%s matched %q with rank %d (%s)", strings.Join(decision.Chosen, ", "), best.Pattern, best.Rank, best.Scope)
+	if len(chosen) > 1 {
+		decision.Reason += ", which is tied"
+	}
+	clio.Debugf("[%s] %s", name, decision.Reason)
+	return chosen, decision
+}
+
+// RoleDecision explains how role preferences decided between profiles which rendered to the same name.
+type RoleDecision struct {
+	// ProfileName is the name the profiles rendered to.
+	ProfileName string
+	// Roles contains the rank of each of the profiles.
+	Roles []RankedRole
+	// Chosen lists the profiles with the best rank, as <account ID>/<role name>.
+	// If there is more than one, the DuplicateResolver decided between them.
+	Chosen []string
+	// Reason is a human-readable explanation of the decision.
+	Reason string
+}
+
+// RankedRole is the rank given to a profile's role by the role preferences.
+type RankedRole struct {
+	// Profile identifies the profile as <account ID>/<role name>.
+	Profile string
+	// Matched is true if any role preference matched the role.
+	Matched bool
+	// Rank is the best rank of the preferences matching the role.
+	Rank int
+	// Pattern is the pattern of the preference which gave the role its rank.
+	Pattern string
+	// Scope describes which accounts the preference applies to.
+	Scope string
+}
@@ -0,0 +1,129 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge_RolePreferences(t *testing.T) {
+	profiles := func() []SSOProfile {
+		var p []SSOProfile
+		for _, account := range []struct{ id, name, ou string }{
+			{"111111111111", "prod", "ou-prod"},
+			{"222222222222", "sandbox", "ou-sandbox"},
+			{"333333333333", "staging", "ou-prod"},
+		} {
+			for _, role := range []string{"Admin", "ReadOnly"} {
+				p = append(p, &AccountProfile{
+					AccountID:          account.id,
+					AccountName:        account.name,
+					OrganizationalUnit: account.ou,
+					RoleName:           role,
+					GeneratedFrom:      "aws-sso",
+				})
+			}
+		}
+		return p
+	}
+
+	tests := []struct {
+		name        string
+		preferRoles []string
+		preferences []RolePreference
+		want        map[string]string
+		wantErr     bool
+	}{
+		{
+			name:        "invalid pattern",
+			preferRoles: []string{"(Admin"},
+			wantErr:     true,
+		},
+		{
+			name:        "prefer roles",
+			preferRoles: []string{"Admin"},
+			want: map[string]string{
+				"prod":    "Admin",
+				"sandbox": "Admin",
+				"staging": "Admin",
+			},
+		},
+		{
+			name: "rank",
+			preferences: []RolePreference{
+				{Pattern: "Admin", Rank: 10},
+				{Pattern: "Read.*", Rank: 5},
+			},
+			want: map[string]string{
+				"prod":    "ReadOnly",
+				"sandbox": "ReadOnly",
+				"staging": "ReadOnly",
+			},
+		},
+		{
+			name:        "scoped overrides",
+			preferRoles: []string{"Admin"},
+			preferences: []RolePreference{
+				{Pattern: "ReadOnly", OrganizationalUnits: []string{"ou-prod"}},
+				{Pattern: "Admin", AccountIDs: []string{"333333333333"}},
+			},
+			want: map[string]string{
+				"prod":    "ReadOnly",
+				"sandbox": "Admin",
+				"staging": "Admin",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, "")
+			_, err := MergeWithReport(MergeOpts{
+				Config:              cfg,
+				Profiles:            profiles(),
+				SectionNameTemplate: "{{ .AccountName }}",
+				PreferRoles:         tt.preferRoles,
+				RolePreferences:     tt.preferences,
+				DuplicateResolver:   DuplicateError,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := make(map[string]string)
+			for name := range tt.want {
+				got[name] = cfg.Section("profile " + name).Key("granted_sso_role_name").String()
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMerge_RoleDecisionTrace(t *testing.T) {
+	report, err := MergeWithReport(MergeOpts{
+		Config:              parseIni(t, ""),
+		SectionNameTemplate: "{{ .AccountName }}",
+		RolePreferences: []RolePreference{
+			{Pattern: "Admin", Rank: 1},
+		},
+		Profiles: []SSOProfile{
+			&AccountProfile{AccountID: "111111111111", AccountName: "prod", RoleName: "Admin"},
+			&AccountProfile{AccountID: "111111111111", AccountName: "prod", RoleName: "ReadOnly"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []RoleDecision{
+		{
+			ProfileName: "prod",
+			Roles: []RankedRole{
+				{Profile: "111111111111/Admin", Matched: true, Rank: 1, Pattern: "Admin", Scope: "global"},
+				{Profile: "111111111111/ReadOnly"},
+			},
+			Chosen: []string{"111111111111/Admin"},
+			Reason: `111111111111/Admin matched "Admin" with rank 1 (global)`,
+		},
+	}, report.RoleDecisions)
+}
@@ -0,0 +1,243 @@
+package awsconfigfile
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/common-fate/clio"
+)
+
+// Profile is implemented by profile types which Merge writes generically,
+// in addition to the built-in SSOSession and AccountProfile types.
+//
+// The profile name is rendered from the profile name template using the profile itself
+// as the template data, and the prefix is added to it. The section is then written like any
+// other generated section: pinned sections are skipped, the conflict policy is applied,
+// and it is pruned once it is no longer generated.
+// The value returned by ToIni must include a 'common_fate_generated_from' key.
+//
+// Profiles which implement SSOProfile but not Profile are rejected by Merge.
+type Profile interface {
+	SSOProfile
+	// Kind identifies the type of profile, such as "assume-role". It must not be empty.
+	Kind() string
+	// SectionName returns the name of the section the profile is written to,
+	// given its profile name. This is usually "profile <profile name>".
+	SectionName(profileName string) string
+}
+
+const (
+	// KindSSOSession is the kind of SSOSession profiles.
+	KindSSOSession = "sso-session"
+	// KindAccount is the kind of AccountProfile profiles.
+	KindAccount = "account"
+)
+
+func (s *SSOSession) Kind() string {
+	return KindSSOSession
+}
+
+func (s *SSOSession) SectionName(profileName string) string {
+	return ssoSessionSectionPrefix + profileName
+}
+
+func (a *AccountProfile) Kind() string {
+	return KindAccount
+}
+
+func (a *AccountProfile) SectionName(profileName string) string {
+	return accountSectionName(profileName)
+}
+
+func (s *SSOSession) WithSourceID(sourceID string) Profile {
+	c := *s
+	if c.SourceID == "" {
+		c.SourceID = sourceID
+	}
+	return &c
+}
+
+func (a *AccountProfile) WithSourceID(sourceID string) Profile {
+	c := *a
+	if c.SourceID == "" {
+		c.SourceID = sourceID
+	}
+	return &c
+}
+
+func accountSectionName(profileName string) string {
+	return "profile " + profileName
+}
+
+// validatedProfile is implemented by profile kinds which check their fields before they are merged.
+type validatedProfile interface {
+	validate() error
+}
+
+// normalizedProfile is implemented by profile kinds with an account name,
+// which is normalized before the profile name template is rendered, as it is for AccountProfile.
+type normalizedProfile interface {
+	normalized() Profile
+}
+
+// commandProfile is implemented by profile kinds which write a credential_process built from an argument list.
+// Profiles with an argument which can't be quoted are skipped.
+type commandProfile interface {
+	credentialProcessArgs() []string
+}
+
+// profileRef identifies a generated profile by its account and role.
+type profileRef struct {
+	AccountID string
+	RoleName  string
+}
+
+func (r profileRef) String() string {
+	return r.AccountID + "/" + r.RoleName
+}
+
+// referencedProfile is implemented by profile kinds whose credentials chained profiles can use.
+type referencedProfile interface {
+	Profile
+	// ref identifies the profile, so that chained profiles can refer to it.
+	ref() profileRef
+}
+
+// chainedProfile is implemented by profile kinds which use the credentials of another generated profile.
+type chainedProfile interface {
+	referencedProfile
+	// sourceRef identifies the generated profile whose credentials are used, if any.
+	sourceRef() (profileRef, bool)
+	// withSourceProfile returns a copy of the profile which uses the named profile's credentials.
+	withSourceProfile(profileName string) Profile
+}
+
+// plannedCustomProfile is a custom profile which is due to be written during a merge.
+type plannedCustomProfile struct {
+	profile     Profile
+	profileName string
+	sectionName string
+}
+
+// skipUnsourcedProfiles removes the chained profiles whose source profile isn't being generated,
+// such as one which was suppressed by an override or skipped because of a conflict,
+// along with the chained profiles which use their credentials in turn.
+func skipUnsourcedProfiles(planned []plannedCustomProfile, generatedNames map[profileRef]string, report *MergeReport) []plannedCustomProfile {
+	for skipped := true; skipped; {
+		skipped = false
+		var kept []plannedCustomProfile
+		for _, p := range planned {
+			c, ok := p.profile.(chainedProfile)
+			if !ok {
+				kept = append(kept, p)
+				continue
+			}
+			source, ok := c.sourceRef()
+			if _, found := generatedNames[source]; !ok || found {
+				kept = append(kept, p)
+				continue
+			}
+			report.addSkipped(p.profileName, fmt.Errorf("it uses the credentials of %s, which wasn't generated", source))
+			if generatedNames[c.ref()] == p.profileName {
+				delete(generatedNames, c.ref())
+			}
+			skipped = true
+		}
+		planned = kept
+	}
+	return planned
+}
+
+// mergeCustomProfiles adds the sections of profiles with custom kinds to the pending changes.
+// generatedNames contains the names of the generated account profiles, and is used
+// to resolve the profiles which chained profiles use the credentials of.
+func mergeCustomProfiles(opts MergeOpts, profiles []Profile, sectionNameTempl *template.Template, bases *baseProfiles, pending *pendingSections, written map[string]bool, generatedNames map[profileRef]string, report *MergeReport) error {
+	var planned []plannedCustomProfile
+	planning := make(map[string]bool)
+
+	for _, p := range profiles {
+		if n, ok := p.(normalizedProfile); ok {
+			p = n.normalized()
+		}
+		sectionNameBuffer := bytes.NewBufferString("")
+		err := sectionNameTempl.Execute(sectionNameBuffer, p)
+		if err != nil {
+			return fmt.Errorf("rendering the profile name for a %s profile: %w", p.Kind(), err)
+		}
+		profileName := opts.Prefix + sectionNameBuffer.String()
+		sectionName := p.SectionName(profileName)
+		clio.Debugf("Processing %s profile: %s", p.Kind(), profileName)
+
+		if c, ok := p.(commandProfile); ok {
+			err = checkCredentialProcessArgs(c.credentialProcessArgs()...)
+			if err != nil {
+				report.addSkipped(profileName, err)
+				continue
+			}
+		}
+
+		if written[sectionName] || planning[sectionName] {
+			return fmt.Errorf("%s profile %s renders to the same section as another generated profile: [%s]", p.Kind(), profileName, sectionName)
+		}
+
+		if isPinnedSection(opts.Config, sectionName, opts.PinnedProfiles) {
+			clio.Infof("Skipping profile %s as it is pinned", profileName)
+			report.addPinned(sectionName)
+			if r, ok := p.(referencedProfile); ok {
+				generatedNames[r.ref()] = profileName
+			}
+			continue
+		}
+
+		conflict, err := resolveConflict(opts, profileName, sectionName, p.SectionName, pending, report)
+		if err != nil {
+			return err
+		}
+		if conflict != nil && conflict.Decision == ConflictSkip {
+			continue
+		}
+		if conflict != nil && conflict.Decision == ConflictRename {
+			profileName = conflict.RenamedTo
+			sectionName = p.SectionName(profileName)
+		}
+
+		planning[sectionName] = true
+		if r, ok := p.(referencedProfile); ok {
+			generatedNames[r.ref()] = profileName
+		}
+		planned = append(planned, plannedCustomProfile{profile: p, profileName: profileName, sectionName: sectionName})
+	}
+	planned = skipUnsourcedProfiles(planned, generatedNames, report)
+
+	for _, planned := range planned {
+		p, profileName, sectionName := planned.profile, planned.profileName, planned.sectionName
+		if c, ok := p.(chainedProfile); ok {
+			if source, ok := c.sourceRef(); ok {
+				p = c.withSourceProfile(generatedNames[source])
+			}
+		}
+
+		generated, err := renderSection(sectionName, p.ToIni(profileName, opts.NoCredentialProcess))
+		if err != nil {
+			return err
+		}
+		// the marker is how Merge recognises the section as generated on the next run
+		if !isGeneratedSection(generated) {
+			return fmt.Errorf("%s profile %s must write a common_fate_generated_from key", p.Kind(), profileName)
+		}
+		if sectionName == accountSectionName(profileName) {
+			var ref profileRef
+			if r, ok := p.(referencedProfile); ok {
+				ref = r.ref()
+			}
+			err = applyBaseProfile(opts, generated, bases.forProfile(ref.AccountID, ref.RoleName, ""))
+			if err != nil {
+				return err
+			}
+		}
+		pending.add(generated, nil)
+		written[sectionName] = true
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticProfile is a custom profile kind which writes a static region.
+type staticProfile struct {
+	AccountName   string
+	RoleName      string
+	Region        string
+	GeneratedFrom string
+}
+
+type staticProfileIni struct {
+	Region                  string `ini:"region"`
+	CommonFateGeneratedFrom string `ini:"common_fate_generated_from,omitempty"`
+}
+
+func (p *staticProfile) ToIni(profileName string, noCredentialProcess bool) any {
+	return &staticProfileIni{Region: p.Region, CommonFateGeneratedFrom: p.GeneratedFrom}
+}
+
+func (p *staticProfile) Kind() string { return "static" }
+
+func (p *staticProfile) SectionName(profileName string) string { return "profile " + profileName }
+
+// unknownProfile implements SSOProfile but not Profile.
+type unknownProfile struct{}
+
+func (p *unknownProfile) ToIni(profileName string, noCredentialProcess bool) any { return nil }
+
+func TestMerge_CustomProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []SSOProfile
+		config   string
+		policy   ConflictPolicy
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "custom profiles are written",
+			profiles: []SSOProfile{&staticProfile{AccountName: "prod", RoleName: "Static", Region: "us-east-1", GeneratedFrom: "static"}},
+			want: `
+[profile prod/Static]
+region                     = us-east-1
+common_fate_generated_from = static
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:     "conflict policy applies",
+			profiles: []SSOProfile{&staticProfile{AccountName: "prod", RoleName: "Static", Region: "us-east-1", GeneratedFrom: "static"}},
+			policy:   ConflictRename,
+			config: `
+[profile prod/Static]
+region = eu-west-1
+`,
+			want: `
+[profile prod/Static]
+region = eu-west-1
+
+[profile prod/Static-generated]
+region                     = us-east-1
+common_fate_generated_from = static
+common_fate_format_version = 1
+`,
+		},
+		{
+			name: "clashes with account profiles are an error",
+			profiles: []SSOProfile{
+				&AccountProfile{AccountID: "123456789012", AccountName: "prod", RoleName: "Static", GeneratedFrom: "aws-sso"},
+				&staticProfile{AccountName: "prod", RoleName: "Static", GeneratedFrom: "static"},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "the generated marker is required",
+			profiles: []SSOProfile{&staticProfile{AccountName: "prod", RoleName: "Static"}},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown profile types are an error",
+			profiles: []SSOProfile{&unknownProfile{}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			err := Merge(MergeOpts{
+				Config:         cfg,
+				Profiles:       tt.profiles,
+				ConflictPolicy: tt.policy,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
+
+func TestAccountProfile_Kind(t *testing.T) {
+	var p Profile = &AccountProfile{}
+	assert.Equal(t, KindAccount, p.Kind())
+	assert.Equal(t, "profile prod", p.SectionName("prod"))
+
+	p = &SSOSession{}
+	assert.Equal(t, KindSSOSession, p.Kind())
+	assert.Equal(t, "sso-session prod", p.SectionName("prod"))
+}
+
+func TestMerge_CustomProfileAccountNamesAreNormalized(t *testing.T) {
+	sso := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "111111111111",
+		AccountName:   "My Account",
+		RoleName:      "Admin",
+		GeneratedFrom: "aws-sso",
+	}
+	profiles := AssumeRoleChains([]SSOProfile{sso}, []AssumeRoleChain{{RoleName: "Deploy", SourceRoleName: "Admin"}})
+	profiles = append(profiles,
+		&WebIdentityProfile{
+			AccountName:   "My CI",
+			AccountID:     "222222222222",
+			RoleName:      "Deploy",
+			RoleARN:       "arn:aws:iam::222222222222:role/Deploy",
+			TokenFile:     "/var/run/secrets/token",
+			GeneratedFrom: "github-actions",
+		},
+		&RolesAnywhereProfile{
+			AccountName:     "Build Hosts",
+			AccountID:       "333333333333",
+			RoleName:        "BuildHost",
+			TrustAnchorARN:  "arn:aws:rolesanywhere:us-east-1:333333333333:trust-anchor/abc",
+			ProfileARN:      "arn:aws:rolesanywhere:us-east-1:333333333333:profile/def",
+			RoleARN:         "arn:aws:iam::333333333333:role/BuildHost",
+			CertificatePath: "/etc/pki/host.pem",
+			PrivateKeyPath:  "/etc/pki/host.key",
+			GeneratedFrom:   "roles-anywhere",
+		},
+	)
+
+	cfg := parseIni(t, "")
+	err := Merge(MergeOpts{Config: cfg, Profiles: profiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, sec := range cfg.Sections() {
+		names = append(names, sec.Name())
+	}
+	assert.Equal(t, []string{
+		"DEFAULT",
+		"profile My-Account/Admin",
+		"profile My-Account/Deploy",
+		"profile My-CI/Deploy",
+		"profile Build-Hosts/BuildHost",
+	}, names)
+	assert.Equal(t, "My-Account/Admin", cfg.Section("profile My-Account/Deploy").Key("source_profile").String())
+}
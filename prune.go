@@ -0,0 +1,183 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/common-fate/clio"
+	"gopkg.in/ini.v1"
+)
+
+// staleSinceKey records when a generated section was first found to be missing from the sources.
+// The section is kept until the prune grace period has passed since then.
+const staleSinceKey = "common_fate_stale_since"
+
+// StaleProfile is a generated section which is no longer returned by the sources,
+// but has been kept because the prune grace period hasn't passed yet.
+type StaleProfile struct {
+	// SectionName is the name of the stale section.
+	SectionName string
+	// StaleSince is when the section was first found to be stale.
+	StaleSince time.Time
+	// PruneAfter is when the section will be removed if it is still stale.
+	PruneAfter time.Time
+}
+
+// pruneCandidates returns the generated sections in the namespace which weren't written during this merge
+// and belong to one of the start URLs or sources being pruned. Pinned sections are never returned.
+func pruneCandidates(opts MergeOpts, written map[string]bool, report *MergeReport) []*ini.Section {
+	var candidates []*ini.Section
+	shared := sharedSSOSessions(opts.Config, opts.Namespace)
+	for _, sec := range opts.Config.Sections() {
+		if written[sec.Name()] || !isOwnedSection(sec, opts.Namespace) {
+			continue
+		}
+		if shared[sec.Name()] {
+			clio.Debugf("Not pruning %s as it is used by profiles outside of this namespace", sec.Name())
+			continue
+		}
+
+		if !inPruneScope(opts, sec) {
+			continue
+		}
+		if isPinned(sec, opts.PinnedProfiles) {
+			clio.Infof("Not pruning %s as it is pinned", sec.Name())
+			report.addPinned(sec.Name())
+			continue
+		}
+		candidates = append(candidates, sec)
+	}
+	return candidates
+}
+
+// inPruneScope returns true if the section belongs to one of the sources or start URLs being pruned.
+func inPruneScope(opts MergeOpts, sec *ini.Section) bool {
+	sourceID := keyValue(sec, "common_fate_source")
+	if sourceID != "" && len(opts.PruneSourceIDs) > 0 {
+		// sections from other sources belong to them, even if they share a start URL
+		return slices.Contains(opts.PruneSourceIDs, sourceID)
+	}
+
+	startURL := sectionStartURL(opts.Config, sec)
+	if startURL == "" {
+		return false
+	}
+	for _, pruneURL := range opts.PruneStartURLs {
+		if sameStartURL(startURL, pruneURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPruneThreshold returns an error if removing the sections would exceed
+// opts.MaxPruneCount or opts.MaxPrunePercent, unless opts.ForcePrune is set.
+// The percentage is taken of the generated sections which are in scope for pruning,
+// including the ones written during this merge.
+func checkPruneThreshold(opts MergeOpts, written map[string]bool, remove []*ini.Section) error {
+	if opts.ForcePrune || len(remove) == 0 {
+		return nil
+	}
+
+	if opts.MaxPruneCount > 0 && len(remove) > opts.MaxPruneCount {
+		return fmt.Errorf("refusing to prune %d profiles as it is more than the maximum of %d: a source may have failed to return its profiles, set ForcePrune to prune them anyway", len(remove), opts.MaxPruneCount)
+	}
+
+	if opts.MaxPrunePercent > 0 {
+		total := len(remove)
+		for _, sec := range opts.Config.Sections() {
+			if written[sec.Name()] && isOwnedSection(sec, opts.Namespace) && inPruneScope(opts, sec) {
+				total++
+			}
+		}
+		percent := float64(len(remove)) / float64(total) * 100
+		if percent > opts.MaxPrunePercent {
+			return fmt.Errorf("refusing to prune %d of %d profiles (%.0f%%) as it is more than the maximum of %g%%: a source may have failed to return its profiles, set ForcePrune to prune them anyway", len(remove), total, percent, opts.MaxPrunePercent)
+		}
+	}
+	return nil
+}
+
+// prune removes generated sections which weren't written during this merge.
+//
+// If opts.PruneGracePeriod is set, sections are first marked as stale and
+// only removed once the grace period has passed since they were marked.
+//
+// Nothing is removed if the number of sections to remove exceeds the prune threshold,
+// and the sections are listed in report.PruneRefused instead.
+func prune(opts MergeOpts, written map[string]bool, report *MergeReport) error {
+	now := opts.currentTime()
+
+	var remove []*ini.Section
+
+	for _, sec := range pruneCandidates(opts, written, report) {
+		if opts.PruneGracePeriod <= 0 {
+			remove = append(remove, sec)
+			continue
+		}
+
+		staleSince, err := time.Parse(time.RFC3339, keyValue(sec, staleSinceKey))
+		if err != nil {
+			// the section has just become stale, or the marker is invalid
+			staleSince = now
+			_, err = sec.NewKey(staleSinceKey, now.Format(time.RFC3339))
+			if err != nil {
+				return err
+			}
+		}
+
+		pruneAfter := staleSince.Add(opts.PruneGracePeriod)
+		if !now.Before(pruneAfter) {
+			clio.Debugf("Pruning %s as it has been stale since %s", sec.Name(), staleSince.Format(time.RFC3339))
+			remove = append(remove, sec)
+			continue
+		}
+
+		clio.Infof("Keeping stale profile %s until %s", sec.Name(), pruneAfter.Format(time.RFC3339))
+		report.Stale = append(report.Stale, StaleProfile{
+			SectionName: sec.Name(),
+			StaleSince:  staleSince,
+			PruneAfter:  pruneAfter,
+		})
+	}
+
+	err := checkPruneThreshold(opts, written, remove)
+	if err != nil {
+		for _, sec := range remove {
+			report.PruneRefused = append(report.PruneRefused, sec.Name())
+		}
+		return err
+	}
+
+	for _, sec := range remove {
+		opts.Config.DeleteSection(sec.Name())
+		report.Pruned = append(report.Pruned, sec.Name())
+	}
+	return nil
+}
+
+// sectionStartURL returns the start URL a section gets its credentials from.
+// Sections which assume a role are followed through source_profile to the profile they use,
+// and LocalStack twins to the profile they mirror.
+func sectionStartURL(cfg *ini.File, sec *ini.Section) string {
+	seen := make(map[string]bool)
+	for !seen[sec.Name()] {
+		seen[sec.Name()] = true
+		if sec.HasKey("granted_sso_start_url") {
+			return sec.Key("granted_sso_start_url").String()
+		}
+		if sec.HasKey("sso_start_url") {
+			return sec.Key("sso_start_url").String()
+		}
+		source, ok := sourceProfileSection(cfg, sec)
+		if !ok {
+			source, ok = mirroredSection(cfg, sec)
+		}
+		if !ok {
+			return ""
+		}
+		sec = source
+	}
+	return ""
+}
@@ -0,0 +1,336 @@
+package awsconfigfile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestMerge_PruneGracePeriod(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	prod := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		SSORegion:     "ap-southeast-2",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+	merge := func(t *testing.T, cfg string, at time.Time, profiles ...SSOProfile) (string, *MergeReport) {
+		t.Helper()
+		c := parseIni(t, cfg)
+		report, err := MergeWithReport(MergeOpts{
+			Config:           c,
+			Profiles:         profiles,
+			PruneStartURLs:   []string{"https://example.awsapps.com/start"},
+			PruneGracePeriod: 24 * time.Hour,
+			now:              func() time.Time { return at },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return writeIni(t, c), report
+	}
+
+	generated := `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
+`
+	stale := `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_region         = ap-southeast-2
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
+common_fate_stale_since    = 2024-01-02T03:04:05Z
+`
+
+	t.Run("missing profiles are marked as stale", func(t *testing.T) {
+		got, report := merge(t, generated, start)
+		assert.Equal(t, trimIni(stale), got)
+		assert.Equal(t, []StaleProfile{{
+			SectionName: "profile prod/DevRole",
+			StaleSince:  start,
+			PruneAfter:  start.Add(24 * time.Hour),
+		}}, report.Stale)
+		assert.Empty(t, report.Pruned)
+	})
+
+	t.Run("stale profiles are kept during the grace period", func(t *testing.T) {
+		got, report := merge(t, stale, start.Add(23*time.Hour))
+		assert.Equal(t, trimIni(stale), got)
+		assert.Len(t, report.Stale, 1)
+	})
+
+	t.Run("stale profiles are pruned after the grace period", func(t *testing.T) {
+		got, report := merge(t, stale, start.Add(24*time.Hour))
+		assert.Equal(t, "", got)
+		assert.Equal(t, []string{"profile prod/DevRole"}, report.Pruned)
+	})
+
+	t.Run("stale marker is cleared when the profile reappears", func(t *testing.T) {
+		got, report := merge(t, stale, start.Add(time.Hour), prod)
+		assert.Equal(t, trimIni(generated), got)
+		assert.Empty(t, report.Stale)
+	})
+}
+
+func TestMerge_PruneThreshold(t *testing.T) {
+	existing := `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+
+[profile staging/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+
+[profile dev/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789014
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+`
+	prod := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+
+	tests := []struct {
+		name     string
+		opts     MergeOpts
+		profiles []SSOProfile
+		// wantPruned lists the sections which are pruned, or which pruning was refused for if wantErr is set
+		wantPruned []string
+		wantErr    bool
+	}{
+		{
+			name:       "no threshold",
+			profiles:   []SSOProfile{prod},
+			wantPruned: []string{"profile staging/DevRole", "profile dev/DevRole"},
+		},
+		{
+			name:       "under the maximum count",
+			opts:       MergeOpts{MaxPruneCount: 2},
+			profiles:   []SSOProfile{prod},
+			wantPruned: []string{"profile staging/DevRole", "profile dev/DevRole"},
+		},
+		{
+			name:       "over the maximum count",
+			opts:       MergeOpts{MaxPruneCount: 1},
+			profiles:   []SSOProfile{prod},
+			wantPruned: []string{"profile staging/DevRole", "profile dev/DevRole"},
+			wantErr:    true,
+		},
+		{
+			name:       "source returned nothing",
+			opts:       MergeOpts{MaxPrunePercent: 50},
+			profiles:   nil,
+			wantPruned: []string{"profile prod/DevRole", "profile staging/DevRole", "profile dev/DevRole"},
+			wantErr:    true,
+		},
+		{
+			name:       "over the maximum percentage",
+			opts:       MergeOpts{MaxPrunePercent: 50},
+			profiles:   []SSOProfile{prod},
+			wantPruned: []string{"profile staging/DevRole", "profile dev/DevRole"},
+			wantErr:    true,
+		},
+		{
+			name:       "under the maximum percentage",
+			opts:       MergeOpts{MaxPrunePercent: 70},
+			profiles:   []SSOProfile{prod},
+			wantPruned: []string{"profile staging/DevRole", "profile dev/DevRole"},
+		},
+		{
+			name:       "forced",
+			opts:       MergeOpts{MaxPruneCount: 1, MaxPrunePercent: 10, ForcePrune: true},
+			profiles:   nil,
+			wantPruned: []string{"profile prod/DevRole", "profile staging/DevRole", "profile dev/DevRole"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Config = parseIni(t, existing)
+			opts.PruneStartURLs = []string{"https://example.awsapps.com/start"}
+			opts.Profiles = tt.profiles
+			report, err := MergeWithReport(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeWithReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				assert.Len(t, opts.Config.Sections(), 4, "no sections should be pruned")
+				assert.Empty(t, report.Pruned)
+				assert.Equal(t, tt.wantPruned, report.PruneRefused)
+				return
+			}
+			assert.Equal(t, tt.wantPruned, report.Pruned)
+			assert.Empty(t, report.PruneRefused)
+		})
+	}
+}
+
+// identifiedSource is a testSource with an ID.
+type identifiedSource struct {
+	testSource
+	id string
+}
+
+func (s identifiedSource) SourceID() string {
+	return s.id
+}
+
+func TestGenerator_PruneSourceIDs(t *testing.T) {
+	existing := `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+common_fate_source         = identity-center
+
+[profile prod/Deploy]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = Deploy
+common_fate_generated_from = commonfate
+common_fate_source         = common-fate
+
+[profile legacy/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789013
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+`
+
+	tests := []struct {
+		name           string
+		pruneStartURLs []string
+		pruneSourceIDs []string
+		want           []string
+	}{
+		{
+			name:           "prune by start url",
+			pruneStartURLs: []string{"https://example.awsapps.com/start"},
+			want:           []string{"profile prod/DevRole"},
+		},
+		{
+			name:           "prune by source",
+			pruneSourceIDs: []string{"identity-center"},
+			want:           []string{"profile prod/DevRole", "profile prod/Deploy", "profile legacy/DevRole"},
+		},
+		{
+			name:           "other sources are not pruned by start url",
+			pruneStartURLs: []string{"https://example.awsapps.com/start"},
+			pruneSourceIDs: []string{"identity-center"},
+			want:           []string{"profile prod/DevRole", "profile prod/Deploy"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, existing)
+			g := &Generator{
+				Config:         cfg,
+				PruneStartURLs: tt.pruneStartURLs,
+				PruneSourceIDs: tt.pruneSourceIDs,
+				Sources: []Source{
+					identifiedSource{id: "identity-center", testSource: testSource{Profiles: []SSOProfile{
+						&AccountProfile{
+							SSOStartURL:   "https://example.awsapps.com/start",
+							AccountID:     "123456789012",
+							AccountName:   "prod",
+							RoleName:      "DevRole",
+							GeneratedFrom: "aws-sso",
+						},
+					}}},
+				},
+			}
+			err := g.Generate(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, sec := range cfg.Sections() {
+				if sec.Name() != ini.DefaultSection {
+					got = append(got, sec.Name())
+				}
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, "identity-center", cfg.Section("profile prod/DevRole").Key("common_fate_source").String())
+		})
+	}
+}
+
+// sourcedProfile is a custom profile kind which records its source ID.
+type sourcedProfile struct {
+	staticProfile
+	SourceID string
+}
+
+type sourcedProfileIni struct {
+	Region                  string `ini:"region"`
+	CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
+}
+
+func (p *sourcedProfile) ToIni(profileName string, noCredentialProcess bool) any {
+	return &sourcedProfileIni{Region: p.Region, CommonFateGeneratedFrom: p.GeneratedFrom, CommonFateSource: p.SourceID}
+}
+
+func (p *sourcedProfile) WithSourceID(sourceID string) Profile {
+	c := *p
+	if c.SourceID == "" {
+		c.SourceID = sourceID
+	}
+	return &c
+}
+
+func TestGenerator_CustomProfileSourceIDs(t *testing.T) {
+	own := &sourcedProfile{staticProfile: staticProfile{AccountName: "prod", RoleName: "Static", Region: "us-east-1", GeneratedFrom: "static"}}
+	cfg := parseIni(t, `
+[profile prod/Old]
+region                     = us-east-1
+common_fate_generated_from = static
+common_fate_source         = static-source
+`)
+	g := &Generator{
+		Config:         cfg,
+		PruneSourceIDs: []string{"static-source"},
+		Sources: []Source{
+			identifiedSource{id: "static-source", testSource: testSource{Profiles: []SSOProfile{own}}},
+		},
+	}
+	err := g.Generate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, `
+[profile prod/Static]
+region                     = us-east-1
+common_fate_generated_from = static
+common_fate_source         = static-source
+common_fate_format_version = 1
+`)
+	assert.Empty(t, own.SourceID)
+}
@@ -0,0 +1,53 @@
+package awsconfigfile
+
+import (
+	"slices"
+
+	"github.com/common-fate/clio"
+)
+
+// MergeReport describes the decisions Merge made while updating the config.
+type MergeReport struct {
+	// Conflicts lists the generated profiles whose names matched a hand-written section.
+	Conflicts []Conflict
+	// Suppressed lists the names of profiles which weren't generated because of an override.
+	Suppressed []string
+	// UnusedOverrides lists the overrides which didn't match any generated profile.
+	UnusedOverrides []ProfileOverride
+	// Pinned lists the sections which were left untouched because they are pinned.
+	Pinned []string
+	// Duplicates lists the profiles which rendered to the same name, and how they were resolved.
+	Duplicates []DuplicateResolution
+	// RoleDecisions explains how role preferences were applied to profiles which rendered to the same name.
+	RoleDecisions []RoleDecision
+	// Pruned lists the generated sections which were removed because they are no longer returned by the sources.
+	Pruned []string
+	// PruneRefused lists the generated sections which would have been pruned, if pruning was refused
+	// because it exceeded MaxPruneCount or MaxPrunePercent. Setting ForcePrune prunes them.
+	PruneRefused []string
+	// Stale lists the generated sections which are no longer returned by the sources,
+	// but were kept because the prune grace period hasn't passed yet.
+	Stale []StaleProfile
+	// Migrated lists the generated sections which were upgraded from an older format version.
+	Migrated []MigratedSection
+	// Skipped lists the profiles which weren't generated because they couldn't be written correctly.
+	Skipped []SkippedProfile
+}
+
+// SkippedProfile is a profile which Merge left out of the config, and the reason it did so.
+type SkippedProfile struct {
+	ProfileName string
+	Reason      string
+}
+
+func (r *MergeReport) addPinned(sectionName string) {
+	if !slices.Contains(r.Pinned, sectionName) {
+		r.Pinned = append(r.Pinned, sectionName)
+	}
+}
+
+// addSkipped records that the profile wasn't generated, and logs a warning.
+func (r *MergeReport) addSkipped(profileName string, reason error) {
+	clio.Warnf("Skipping profile %s: %s", profileName, reason)
+	r.Skipped = append(r.Skipped, SkippedProfile{ProfileName: profileName, Reason: reason.Error()})
+}
@@ -0,0 +1,114 @@
+package awsconfigfile
+
+import (
+	"testing"
+)
+
+func TestMerge_RolesAnywhereProfile(t *testing.T) {
+	profile := func() *RolesAnywhereProfile {
+		return &RolesAnywhereProfile{
+			AccountName:     "build",
+			AccountID:       "123456789012",
+			RoleName:        "BuildHost",
+			TrustAnchorARN:  "arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/abc",
+			ProfileARN:      "arn:aws:rolesanywhere:us-east-1:123456789012:profile/def",
+			RoleARN:         "arn:aws:iam::123456789012:role/BuildHost",
+			CertificatePath: "/etc/pki/host.pem",
+			PrivateKeyPath:  "/etc/pki/host.key",
+			Region:          "us-east-1",
+			GeneratedFrom:   "roles-anywhere",
+			SourceID:        "build-hosts",
+		}
+	}
+	withDuration := profile()
+	withDuration.SessionDuration = 900
+	withDuration.SigningHelper = "/usr/local/bin/aws_signing_helper"
+	invalid := profile()
+	invalid.CertificatePath = ""
+	spaced := profile()
+	spaced.CertificatePath = "/etc/pki/build host.pem"
+	unquotable := profile()
+	unquotable.PrivateKeyPath = "/etc/pki/host.key\n"
+
+	tests := []struct {
+		name     string
+		profiles []SSOProfile
+		config   string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "ok",
+			profiles: []SSOProfile{profile()},
+			want: `
+[profile build/BuildHost]
+credential_process         = aws_signing_helper credential-process --certificate /etc/pki/host.pem --private-key /etc/pki/host.key --trust-anchor-arn arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/abc --profile-arn arn:aws:rolesanywhere:us-east-1:123456789012:profile/def --role-arn arn:aws:iam::123456789012:role/BuildHost
+common_fate_generated_from = roles-anywhere
+common_fate_source         = build-hosts
+region                     = us-east-1
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:     "session duration and signing helper",
+			profiles: []SSOProfile{withDuration},
+			want: `
+[profile build/BuildHost]
+credential_process         = /usr/local/bin/aws_signing_helper credential-process --certificate /etc/pki/host.pem --private-key /etc/pki/host.key --trust-anchor-arn arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/abc --profile-arn arn:aws:rolesanywhere:us-east-1:123456789012:profile/def --role-arn arn:aws:iam::123456789012:role/BuildHost --session-duration 900
+common_fate_generated_from = roles-anywhere
+common_fate_source         = build-hosts
+region                     = us-east-1
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:     "paths are quoted",
+			profiles: []SSOProfile{spaced},
+			want: `
+[profile build/BuildHost]
+credential_process         = aws_signing_helper credential-process --certificate '/etc/pki/build host.pem' --private-key /etc/pki/host.key --trust-anchor-arn arn:aws:rolesanywhere:us-east-1:123456789012:trust-anchor/abc --profile-arn arn:aws:rolesanywhere:us-east-1:123456789012:profile/def --role-arn arn:aws:iam::123456789012:role/BuildHost
+common_fate_generated_from = roles-anywhere
+common_fate_source         = build-hosts
+region                     = us-east-1
+common_fate_format_version = 1
+`,
+		},
+		{
+			name:     "profiles which can't be quoted are skipped",
+			profiles: []SSOProfile{unquotable},
+			want:     ``,
+		},
+		{
+			name:     "missing certificate",
+			profiles: []SSOProfile{invalid},
+			wantErr:  true,
+		},
+		{
+			name: "pruned by source",
+			config: `
+[profile build/OldHost]
+credential_process         = aws_signing_helper credential-process
+common_fate_generated_from = roles-anywhere
+common_fate_source         = build-hosts
+`,
+			want: ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, tt.config)
+			err := Merge(MergeOpts{
+				Config:         cfg,
+				Profiles:       tt.profiles,
+				PruneSourceIDs: []string{"build-hosts"},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			assertIni(t, cfg, tt.want)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// shellSafePunctuation are the characters other than letters and digits which mean nothing
+// to POSIX shells, cmd.exe or the command splitters of the AWS SDKs.
+const shellSafePunctuation = "_-+=@:,./"
+
+// shellSafeChars are the characters a credential_process argument may contain without being quoted.
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789" + shellSafePunctuation
+
+// commandStyle is the set of rules a credential_process command is split into arguments with.
+type commandStyle int
+
+const (
+	// posixCommand is used on Linux and macOS, where the AWS SDKs split the command
+	// with POSIX shell rules or run it with 'sh -c'.
+	posixCommand commandStyle = iota
+	// windowsCommand is used on Windows, where the AWS SDKs split the command with
+	// the double quote rules of CommandLineToArgvW or run it with 'cmd.exe /C'.
+	windowsCommand
+)
+
+// credentialProcessStyle is the style credential_process commands are written in.
+// The config is read by the SDKs on the machine it is generated on, so it follows the host.
+var credentialProcessStyle = hostCommandStyle(runtime.GOOS)
+
+func hostCommandStyle(goos string) commandStyle {
+	if goos == "windows" {
+		return windowsCommand
+	}
+	return posixCommand
+}
+
+// unquotableArgError is returned for a credential_process argument which can't be quoted,
+// such as one containing a newline. Merge skips the profiles it is returned for.
+type unquotableArgError struct {
+	arg    string
+	reason string
+}
+
+func (e *unquotableArgError) Error() string {
+	return fmt.Sprintf("%q can't be quoted in a credential process: %s", e.arg, e.reason)
+}
+
+// checkCredentialProcessArg returns an error if the argument can't be quoted in the given style.
+// Line breaks never can. On Windows, double quotes can't be escaped in a way both cmd.exe and
+// CommandLineToArgvW understand, and cmd.exe expands %VAR% even inside double quotes.
+func checkCredentialProcessArg(arg string, style commandStyle) error {
+	if strings.ContainsAny(arg, "\r\n\x00") {
+		return &unquotableArgError{arg: arg, reason: "it contains a line break or NUL character"}
+	}
+	if style == windowsCommand && strings.ContainsAny(arg, `"%`) {
+		return &unquotableArgError{arg: arg, reason: `double quotes and % can't be quoted for cmd.exe`}
+	}
+	return nil
+}
+
+// quoteCredentialProcessArg quotes the argument in the given style, so that it is passed to the
+// program as it is. Arguments made only of shellSafeChars are returned unchanged.
+func quoteCredentialProcessArg(arg string, style commandStyle) (string, error) {
+	err := checkCredentialProcessArg(arg, style)
+	if err != nil {
+		return "", err
+	}
+	if arg != "" && strings.Trim(arg, shellSafeChars) == "" {
+		return arg, nil
+	}
+	if style == windowsCommand {
+		// backslashes are only special before a double quote, so the ones before the closing quote are doubled
+		trailing := len(arg) - len(strings.TrimRight(arg, `\`))
+		return `"` + arg + strings.Repeat(`\`, trailing) + `"`, nil
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'", nil
+}
+
+// credentialProcessCommand builds a credential_process command from its arguments, quoting them
+// in the host's style. The arguments must have been checked with checkCredentialProcessArgs.
+func credentialProcessCommand(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		// the arguments have been checked, so quoting can't fail
+		quoted[i], _ = quoteCredentialProcessArg(arg, credentialProcessStyle)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// checkCredentialProcessArgs returns an error if any of the arguments can't be quoted in the host's style.
+func checkCredentialProcessArgs(args ...string) error {
+	for _, arg := range args {
+		err := checkCredentialProcessArg(arg, credentialProcessStyle)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitCommand splits a command into its arguments with POSIX shell rules, which are the rules
+// the AWS SDKs use on Linux and macOS. Whitespace separates arguments, single quotes preserve
+// everything up to the closing quote, and a backslash escapes the next character, or inside double quotes
+// one of $ ` " \. Expansions such as $HOME are not performed.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case ch == '\\':
+			if i+1 == len(command) {
+				return nil, fmt.Errorf("command ends with an unescaped backslash")
+			}
+			i++
+			arg.WriteByte(command[i])
+			inArg = true
+		case ch == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("command has an unterminated single quote")
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case ch == '"':
+			closed := false
+			for i++; i < len(command); i++ {
+				if command[i] == '"' {
+					closed = true
+					break
+				}
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("$`\"\\", command[i+1]) >= 0 {
+					i++
+				}
+				arg.WriteByte(command[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("command has an unterminated double quote")
+			}
+			inArg = true
+		default:
+			arg.WriteByte(ch)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
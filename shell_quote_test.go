@@ -0,0 +1,265 @@
+package awsconfigfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialProcessCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "safe",
+			args: []string{"granted", "credential-process", "--profile", "prod/DevRole", "--url", "https://commonfate.example.com"},
+			want: "granted credential-process --profile prod/DevRole --url https://commonfate.example.com",
+		},
+		{
+			name: "forward slash paths",
+			args: []string{"/opt/tools/helper", "--certificate", "C:/certs/host.pem"},
+			want: "/opt/tools/helper --certificate C:/certs/host.pem",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, checkCredentialProcessArgs(tt.args...))
+			assert.Equal(t, tt.want, credentialProcessCommand(tt.args...))
+		})
+	}
+}
+
+func TestQuoteCredentialProcessArg(t *testing.T) {
+	tests := []struct {
+		arg     string
+		posix   string
+		windows string
+	}{
+		{arg: "prod/DevRole", posix: "prod/DevRole", windows: "prod/DevRole"},
+		{arg: "", posix: "''", windows: `""`},
+		{arg: "Prod-(EU)/Admin", posix: "'Prod-(EU)/Admin'", windows: `"Prod-(EU)/Admin"`},
+		{arg: "R&D/Admin", posix: "'R&D/Admin'", windows: `"R&D/Admin"`},
+		{arg: "it's", posix: `'it'\''s'`, windows: `"it's"`},
+		{arg: "prod$(whoami)", posix: "'prod$(whoami)'", windows: `"prod$(whoami)"`},
+		{arg: "`id`", posix: "'`id`'", windows: "\"`id`\""},
+		{arg: "a|b;c", posix: "'a|b;c'", windows: `"a|b;c"`},
+		{arg: "https://cf.example.com/?tenant=x", posix: "'https://cf.example.com/?tenant=x'", windows: `"https://cf.example.com/?tenant=x"`},
+		{arg: "https://x.awsapps.com/start#/", posix: "'https://x.awsapps.com/start#/'", windows: `"https://x.awsapps.com/start#/"`},
+		{arg: "/opt/my tools/helper", posix: "'/opt/my tools/helper'", windows: `"/opt/my tools/helper"`},
+		{arg: `C:\certs\host.pem`, posix: `'C:\certs\host.pem'`, windows: `"C:\certs\host.pem"`},
+		{arg: `C:\certs\`, posix: `'C:\certs\'`, windows: `"C:\certs\\"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			got, err := quoteCredentialProcessArg(tt.arg, posixCommand)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.posix, got)
+			got, err = quoteCredentialProcessArg(tt.arg, windowsCommand)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.windows, got)
+		})
+	}
+}
+
+func TestQuoteCredentialProcessArg_Unquotable(t *testing.T) {
+	for _, arg := range []string{"new\nline", "carriage\rreturn", "nul\x00"} {
+		_, err := quoteCredentialProcessArg(arg, posixCommand)
+		assert.Error(t, err, arg)
+		_, err = quoteCredentialProcessArg(arg, windowsCommand)
+		assert.Error(t, err, arg)
+	}
+	for _, arg := range []string{`say "hi"`, "100%", "%PATH%"} {
+		_, err := quoteCredentialProcessArg(arg, posixCommand)
+		assert.NoError(t, err, arg)
+		_, err = quoteCredentialProcessArg(arg, windowsCommand)
+		assert.Error(t, err, arg)
+	}
+}
+
+func TestQuotedCredentialProcessArgsSplitBack(t *testing.T) {
+	args := []string{"granted", "--profile", "Prod-(EU)/it's $(id)", "--url", "https://x.awsapps.com/start#/", ""}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		var err error
+		quoted[i], err = quoteCredentialProcessArg(arg, posixCommand)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := splitCommand(strings.Join(quoted, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, args, got)
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "plain",
+			command: "granted credential-process  --profile\tprod/DevRole",
+			want:    []string{"granted", "credential-process", "--profile", "prod/DevRole"},
+		},
+		{
+			name:    "single quotes",
+			command: `'/opt/my tools/granted' --profile 'it'\''s'`,
+			want:    []string{"/opt/my tools/granted", "--profile", "it's"},
+		},
+		{
+			name:    "double quotes",
+			command: `"/opt/my tools/granted" --name "say \"hi\" \n"`,
+			want:    []string{"/opt/my tools/granted", "--name", `say "hi" \n`},
+		},
+		{
+			name:    "backslashes",
+			command: `/opt/my\ tools/granted --x a\'b`,
+			want:    []string{"/opt/my tools/granted", "--x", "a'b"},
+		},
+		{
+			name:    "empty quotes",
+			command: `helper ''`,
+			want:    []string{"helper", ""},
+		},
+		{
+			name:    "unterminated single quote",
+			command: "'/opt/my tools/granted",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated double quote",
+			command: `"/opt/my tools/granted`,
+			wantErr: true,
+		},
+		{
+			name:    "trailing backslash",
+			command: `granted \`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommand(tt.command)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMerge_CredentialProcessArgs(t *testing.T) {
+	profile := &AccountProfile{
+		SSOStartURL:   "https://example.awsapps.com/start",
+		AccountID:     "123456789012",
+		AccountName:   "prod",
+		RoleName:      "DevRole",
+		GeneratedFrom: "aws-sso",
+	}
+
+	tests := []struct {
+		name                string
+		sectionNameTemplate string
+		credentialProcess   string
+		want                string
+		wantErr             bool
+	}{
+		{
+			name: "safe names are written as they are",
+			want: "granted credential-process --profile prod/DevRole",
+		},
+		{
+			name:                "names with shell characters are quoted",
+			sectionNameTemplate: "{{ .AccountName }}&{{ .RoleName }}",
+			want:                "granted credential-process --profile 'prod&DevRole'",
+		},
+		{
+			name:                "names with quotes are quoted",
+			sectionNameTemplate: "{{ .AccountName }}'{{ .RoleName }}",
+			want:                `granted credential-process --profile 'prod'\''DevRole'`,
+		},
+		{
+			name:                "template values are quoted",
+			sectionNameTemplate: "{{ .AccountName }}/{{ .RoleName }}$(id)",
+			credentialProcess:   "aws-vault exec {{ .ProfileName }} --json",
+			want:                "aws-vault exec 'prod/DevRole$(id)' --json",
+		},
+		{
+			name:              "templates can quote other values",
+			credentialProcess: "granted credential-process --profile {{ .ProfileName }} --team {{ \"R&D\" | quotearg }}",
+			want:              "granted credential-process --profile prod/DevRole --team 'R&D'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := parseIni(t, "")
+			err := Merge(MergeOpts{
+				Config:                    cfg,
+				Profiles:                  []SSOProfile{profile},
+				SectionNameTemplate:       tt.sectionNameTemplate,
+				CredentialProcessTemplate: tt.credentialProcess,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var got string
+			for _, sec := range cfg.Sections() {
+				if sec.HasKey("credential_process") {
+					got = sec.Key("credential_process").String()
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMerge_UnquotableProfilesAreSkipped(t *testing.T) {
+	cfg := parseIni(t, "")
+	report, err := MergeWithReport(MergeOpts{
+		Config: cfg,
+		Profiles: []SSOProfile{
+			&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				AccountID:     "123456789012",
+				AccountName:   "Prod (EU)",
+				RoleName:      "Admin",
+				GeneratedFrom: "aws-sso",
+				CommonFateURL: "https://cf.example.com/?tenant=x",
+			},
+			&AccountProfile{
+				SSOStartURL:   "https://example.awsapps.com/start",
+				AccountID:     "123456789013",
+				AccountName:   "dev",
+				RoleName:      "Admin",
+				GeneratedFrom: "aws-sso",
+				CommonFateURL: "https://cf.example.com/\nregion = eu-west-1",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, `
+[profile Prod-(EU)/Admin]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = Admin
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile 'Prod-(EU)/Admin' --url 'https://cf.example.com/?tenant=x'
+common_fate_format_version = 1
+`)
+	if assert.Len(t, report.Skipped, 1) {
+		assert.Equal(t, "dev/Admin", report.Skipped[0].ProfileName)
+		assert.Contains(t, report.Skipped[0].Reason, "can't be quoted")
+	}
+}
@@ -0,0 +1,58 @@
+package awsconfigfile
+
+import (
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+const ssoSessionSectionPrefix = "sso-session "
+
+// ssoSessionKey identifies an AWS SSO instance by its start URL and region.
+type ssoSessionKey struct {
+	startURL string
+	region   string
+}
+
+func newSSOSessionKey(startURL, region string) ssoSessionKey {
+	return ssoSessionKey{
+		startURL: normalizeStartURL(startURL),
+		region:   strings.ToLower(strings.TrimSpace(region)),
+	}
+}
+
+// existingSSOSessions returns the sso-session sections in the config which weren't generated in the namespace,
+// keyed by their normalized start URL and region. These are the sessions written by hand
+// and the sessions generated in other namespaces, which Merge reuses but never rewrites.
+func existingSSOSessions(cfg *ini.File, namespace string) map[ssoSessionKey]string {
+	sessions := make(map[ssoSessionKey]string)
+	for _, sec := range cfg.Sections() {
+		if !strings.HasPrefix(sec.Name(), ssoSessionSectionPrefix) || isOwnedSection(sec, namespace) {
+			continue
+		}
+		if !sec.HasKey("sso_start_url") {
+			continue
+		}
+		key := newSSOSessionKey(keyValue(sec, "sso_start_url"), keyValue(sec, "sso_region"))
+		// if there are several matching sessions, prefer the first one in the file.
+		if _, ok := sessions[key]; !ok {
+			sessions[key] = strings.TrimPrefix(sec.Name(), ssoSessionSectionPrefix)
+		}
+	}
+	return sessions
+}
+
+// sharedSSOSessions returns the names of the sso-session sections referenced by profiles
+// which weren't generated in the namespace, such as the profiles of another namespace reusing them.
+func sharedSSOSessions(cfg *ini.File, namespace string) map[string]bool {
+	shared := make(map[string]bool)
+	for _, sec := range cfg.Sections() {
+		if isOwnedSection(sec, namespace) {
+			continue
+		}
+		if name := keyValue(sec, "sso_session"); name != "" {
+			shared[ssoSessionSectionPrefix+name] = true
+		}
+	}
+	return shared
+}
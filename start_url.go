@@ -0,0 +1,160 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// StartURLFormat is the format of an IAM Identity Center start URL.
+type StartURLFormat string
+
+const (
+	// StartURLAWSApps is the original format, https://<subdomain>.awsapps.com/start.
+	StartURLAWSApps StartURLFormat = "awsapps"
+	// StartURLGovCloud is the format used in the AWS GovCloud (US) partition,
+	// https://start.us-gov-home.awsapps.com/directory/<subdomain>.
+	StartURLGovCloud StartURLFormat = "govcloud"
+	// StartURLIssuer is the issuer URL format, https://ssoins-<id>.portal.<region>.app.aws.
+	StartURLIssuer StartURLFormat = "issuer"
+	// StartURLUnknown is any other URL. It is normalized, but the partition and instance aren't known.
+	StartURLUnknown StartURLFormat = "unknown"
+)
+
+// StartURL is a parsed IAM Identity Center start URL.
+type StartURL struct {
+	// Raw is the URL as it was provided.
+	Raw string
+	// Normalized is the canonical form of the URL.
+	// Two start URLs for the same instance and format have the same normalized form.
+	Normalized string
+	Format     StartURLFormat
+	// Partition is the AWS partition of the instance, such as aws, aws-cn or aws-us-gov.
+	Partition string
+	// Region is the region of the instance. It is only known for issuer URLs.
+	Region string
+	// InstanceID identifies the instance: the ssoins- ID for issuer URLs,
+	// or the subdomain (a directory ID or alias) for awsapps URLs.
+	InstanceID string
+}
+
+func (u StartURL) String() string {
+	return u.Normalized
+}
+
+var (
+	awsAppsHostRegex  = regexp.MustCompile(`^([a-z0-9-]+)\.awsapps\.(com|cn)$`)
+	govCloudPathRegex = regexp.MustCompile(`^/directory/([a-z0-9-]+)$`)
+	issuerHostRegex   = regexp.MustCompile(`^(ssoins-[a-z0-9]+)\.portal\.([a-z0-9-]+)\.app\.aws$`)
+)
+
+const govCloudHost = "start.us-gov-home.awsapps.com"
+
+// ParseStartURL parses and normalizes an IAM Identity Center start URL.
+// Trailing slashes, queries and fragments such as '#/' are ignored, and the host is lowercased.
+//
+// An error is returned if the URL isn't an absolute http(s) URL. URLs which don't match
+// a known format are returned with the StartURLUnknown format.
+func ParseStartURL(raw string) (StartURL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return StartURL{}, fmt.Errorf("invalid start URL %q: %w", raw, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "https" && scheme != "http") || u.Host == "" {
+		return StartURL{}, fmt.Errorf("invalid start URL %q: must be an absolute https URL", raw)
+	}
+
+	host := strings.ToLower(u.Host)
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	result := StartURL{Raw: raw, Format: StartURLUnknown}
+
+	if host == govCloudHost {
+		if m := govCloudPathRegex.FindStringSubmatch(strings.ToLower(path)); m != nil {
+			result.Format = StartURLGovCloud
+			result.Partition = "aws-us-gov"
+			result.InstanceID = m[1]
+			result.Normalized = "https://" + host + "/directory/" + m[1]
+			return result, nil
+		}
+	}
+
+	if m := awsAppsHostRegex.FindStringSubmatch(host); m != nil && (path == "" || path == "/start") {
+		result.Format = StartURLAWSApps
+		result.Partition = "aws"
+		if m[2] == "cn" {
+			result.Partition = "aws-cn"
+		}
+		result.InstanceID = m[1]
+		result.Normalized = "https://" + host + "/start"
+		return result, nil
+	}
+
+	if m := issuerHostRegex.FindStringSubmatch(host); m != nil && path == "" {
+		result.Format = StartURLIssuer
+		result.InstanceID = m[1]
+		result.Region = m[2]
+		result.Partition = regionPartition(m[2])
+		result.Normalized = "https://" + host
+		return result, nil
+	}
+
+	u.Scheme = scheme
+	u.Host = host
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	result.Normalized = u.String()
+	return result, nil
+}
+
+// regionPartition returns the AWS partition a region belongs to.
+func regionPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	}
+	return "aws"
+}
+
+// normalizeStartURL makes start URLs comparable.
+// Strings which can't be parsed as URLs are compared after trimming and lowercasing them.
+func normalizeStartURL(startURL string) string {
+	u, err := ParseStartURL(startURL)
+	if err != nil {
+		return strings.TrimRight(strings.ToLower(strings.TrimSpace(startURL)), "/")
+	}
+	return u.Normalized
+}
+
+// sameStartURL returns true if the start URLs refer to the same instance.
+func sameStartURL(a, b string) bool {
+	return normalizeStartURL(a) == normalizeStartURL(b)
+}
+
+// validateStartURLs returns an error if any of the start URLs used by the merge are invalid.
+func validateStartURLs(opts MergeOpts) error {
+	urls := append([]string{}, opts.PruneStartURLs...)
+	for _, p := range opts.Profiles {
+		switch p := p.(type) {
+		case *AccountProfile:
+			urls = append(urls, p.SSOStartURL)
+		case *SSOSession:
+			urls = append(urls, p.SSOStartURL)
+		}
+	}
+	for _, u := range urls {
+		if u == "" {
+			continue
+		}
+		_, err := ParseStartURL(u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStartURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    StartURL
+		wantErr bool
+	}{
+		{
+			name: "awsapps",
+			raw:  "https://example.awsapps.com/start",
+			want: StartURL{Normalized: "https://example.awsapps.com/start", Format: StartURLAWSApps, Partition: "aws", InstanceID: "example"},
+		},
+		{
+			name: "awsapps with trailing slash",
+			raw:  "https://example.awsapps.com/start/",
+			want: StartURL{Normalized: "https://example.awsapps.com/start", Format: StartURLAWSApps, Partition: "aws", InstanceID: "example"},
+		},
+		{
+			name: "awsapps with fragment",
+			raw:  "https://Example.awsapps.com/start#/",
+			want: StartURL{Normalized: "https://example.awsapps.com/start", Format: StartURLAWSApps, Partition: "aws", InstanceID: "example"},
+		},
+		{
+			name: "awsapps without path",
+			raw:  "https://d-1234567890.awsapps.com",
+			want: StartURL{Normalized: "https://d-1234567890.awsapps.com/start", Format: StartURLAWSApps, Partition: "aws", InstanceID: "d-1234567890"},
+		},
+		{
+			name: "china",
+			raw:  "https://example.awsapps.cn/start",
+			want: StartURL{Normalized: "https://example.awsapps.cn/start", Format: StartURLAWSApps, Partition: "aws-cn", InstanceID: "example"},
+		},
+		{
+			name: "govcloud",
+			raw:  "https://start.us-gov-home.awsapps.com/directory/d-1234567890/",
+			want: StartURL{Normalized: "https://start.us-gov-home.awsapps.com/directory/d-1234567890", Format: StartURLGovCloud, Partition: "aws-us-gov", InstanceID: "d-1234567890"},
+		},
+		{
+			name: "issuer",
+			raw:  "https://ssoins-1234567890abcdef.portal.ap-southeast-2.app.aws/",
+			want: StartURL{Normalized: "https://ssoins-1234567890abcdef.portal.ap-southeast-2.app.aws", Format: StartURLIssuer, Partition: "aws", Region: "ap-southeast-2", InstanceID: "ssoins-1234567890abcdef"},
+		},
+		{
+			name: "issuer in govcloud",
+			raw:  "https://ssoins-1234567890abcdef.portal.us-gov-west-1.app.aws",
+			want: StartURL{Normalized: "https://ssoins-1234567890abcdef.portal.us-gov-west-1.app.aws", Format: StartURLIssuer, Partition: "aws-us-gov", Region: "us-gov-west-1", InstanceID: "ssoins-1234567890abcdef"},
+		},
+		{
+			name: "unknown",
+			raw:  "https://Login.Example.com/sso/?foo=bar",
+			want: StartURL{Normalized: "https://login.example.com/sso", Format: StartURLUnknown},
+		},
+		{
+			name:    "not a url",
+			raw:     "example.awsapps.com/start",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStartURL(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStartURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Raw = tt.raw
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMerge_PruneNormalizedStartURL(t *testing.T) {
+	cfg := parseIni(t, `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start/
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+`)
+	err := Merge(MergeOpts{
+		Config:         cfg,
+		PruneStartURLs: []string{"https://Example.awsapps.com/start#/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, "")
+}
+
+func TestMerge_InvalidStartURL(t *testing.T) {
+	err := Merge(MergeOpts{
+		Config: parseIni(t, ""),
+		Profiles: []SSOProfile{
+			&AccountProfile{SSOStartURL: "example.awsapps.com/start", AccountID: "123456789012", AccountName: "prod", RoleName: "DevRole"},
+		},
+	})
+	assert.Error(t, err)
+}
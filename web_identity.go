@@ -0,0 +1,122 @@
+package awsconfigfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KindWebIdentity is the kind of WebIdentityProfile profiles.
+const KindWebIdentity = "web-identity"
+
+// WebIdentityProfile is a profile which assumes a role with an OIDC token read from a file,
+// as used by CI runners and Kubernetes workloads. It is written with role_arn and web_identity_token_file,
+// and AssumeRoleProfiles can use it as their source.
+type WebIdentityProfile struct {
+	// AccountID and RoleName are taken from the role ARN by NewWebIdentityProfile,
+	// and AccountName defaults to the account ID. All three are available to the profile name template.
+	AccountName string
+	AccountID   string
+	RoleName    string
+
+	RoleARN string
+	// TokenFile is the path to the file containing the OIDC token.
+	TokenFile       string
+	RoleSessionName string
+	Region          string
+	GeneratedFrom   string
+	SourceID        string
+}
+
+// NewWebIdentityProfile returns a web identity profile for the role, with the account ID and role name
+// taken from the role ARN. The account name defaults to the account ID.
+func NewWebIdentityProfile(roleARN string, tokenFile string, generatedFrom string) (*WebIdentityProfile, error) {
+	accountID, roleName, err := parseRoleARN(roleARN)
+	if err != nil {
+		return nil, err
+	}
+	p := &WebIdentityProfile{
+		AccountName:   accountID,
+		AccountID:     accountID,
+		RoleName:      roleName,
+		RoleARN:       roleARN,
+		TokenFile:     tokenFile,
+		GeneratedFrom: generatedFrom,
+	}
+	err = p.validate()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseRoleARN returns the account ID and role name from an IAM role ARN,
+// such as arn:aws:iam::123456789012:role/path/Name. The path is not included in the role name.
+func parseRoleARN(roleARN string) (accountID string, roleName string, err error) {
+	parts := strings.SplitN(roleARN, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" || !strings.HasPrefix(parts[5], "role/") {
+		return "", "", fmt.Errorf("invalid role ARN %q", roleARN)
+	}
+	resource := strings.TrimPrefix(parts[5], "role/")
+	roleName = resource[strings.LastIndex(resource, "/")+1:]
+	if parts[4] == "" || roleName == "" {
+		return "", "", fmt.Errorf("invalid role ARN %q", roleARN)
+	}
+	return parts[4], roleName, nil
+}
+
+type webIdentityProfile struct {
+	RoleARN                 string `ini:"role_arn"`
+	WebIdentityTokenFile    string `ini:"web_identity_token_file"`
+	RoleSessionName         string `ini:"role_session_name,omitempty"`
+	CommonFateGeneratedFrom string `ini:"common_fate_generated_from"`
+	CommonFateSource        string `ini:"common_fate_source,omitempty"`
+	Region                  string `ini:"region,omitempty"`
+}
+
+func (w *WebIdentityProfile) ToIni(profileName string, noCredentialProcess bool) any {
+	return &webIdentityProfile{
+		RoleARN:                 w.RoleARN,
+		WebIdentityTokenFile:    w.TokenFile,
+		RoleSessionName:         w.RoleSessionName,
+		CommonFateGeneratedFrom: w.GeneratedFrom,
+		CommonFateSource:        w.SourceID,
+		Region:                  w.Region,
+	}
+}
+
+func (w *WebIdentityProfile) validate() error {
+	if w.RoleARN == "" {
+		return fmt.Errorf("%s profile for %s/%s has no role ARN", KindWebIdentity, w.AccountID, w.RoleName)
+	}
+	if w.TokenFile == "" {
+		return fmt.Errorf("%s profile for %s has no token file", KindWebIdentity, w.RoleARN)
+	}
+	return nil
+}
+
+func (w *WebIdentityProfile) WithSourceID(sourceID string) Profile {
+	c := *w
+	if c.SourceID == "" {
+		c.SourceID = sourceID
+	}
+	return &c
+}
+
+func (w *WebIdentityProfile) normalized() Profile {
+	c := *w
+	c.AccountName = normalizeAccountName(c.AccountName)
+	return &c
+}
+
+func (w *WebIdentityProfile) Kind() string {
+	return KindWebIdentity
+}
+
+func (w *WebIdentityProfile) SectionName(profileName string) string {
+	return accountSectionName(profileName)
+}
+
+// ref allows assume-role profiles to use the credentials of web identity profiles.
+func (w *WebIdentityProfile) ref() profileRef {
+	return profileRef{AccountID: w.AccountID, RoleName: w.RoleName}
+}
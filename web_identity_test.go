@@ -0,0 +1,108 @@
+package awsconfigfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWebIdentityProfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		roleARN   string
+		tokenFile string
+		want      *WebIdentityProfile
+		wantErr   bool
+	}{
+		{
+			name:      "ok",
+			roleARN:   "arn:aws:iam::123456789012:role/ci/Deploy",
+			tokenFile: "/var/run/secrets/token",
+			want: &WebIdentityProfile{
+				AccountName:   "123456789012",
+				AccountID:     "123456789012",
+				RoleName:      "Deploy",
+				RoleARN:       "arn:aws:iam::123456789012:role/ci/Deploy",
+				TokenFile:     "/var/run/secrets/token",
+				GeneratedFrom: "github-actions",
+			},
+		},
+		{
+			name:      "not a role",
+			roleARN:   "arn:aws:iam::123456789012:user/Deploy",
+			tokenFile: "/var/run/secrets/token",
+			wantErr:   true,
+		},
+		{
+			name:    "missing token file",
+			roleARN: "arn:aws:iam::123456789012:role/Deploy",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewWebIdentityProfile(tt.roleARN, tt.tokenFile, "github-actions")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWebIdentityProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMerge_WebIdentityProfile(t *testing.T) {
+	ci, err := NewWebIdentityProfile("arn:aws:iam::123456789012:role/Deploy", "/var/run/secrets/token", "github-actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci.AccountName = "prod"
+	ci.RoleSessionName = "ci"
+
+	cfg := parseIni(t, "")
+	err = Merge(MergeOpts{
+		Config: cfg,
+		Profiles: []SSOProfile{
+			&AccountProfile{SSOStartURL: "https://example.awsapps.com/start", AccountID: "123456789012", AccountName: "prod", RoleName: "DevRole", GeneratedFrom: "aws-sso"},
+			ci,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIni(t, cfg, `
+[profile prod/DevRole]
+granted_sso_start_url      = https://example.awsapps.com/start
+granted_sso_account_id     = 123456789012
+granted_sso_role_name      = DevRole
+common_fate_generated_from = aws-sso
+credential_process         = granted credential-process --profile prod/DevRole
+common_fate_format_version = 1
+
+[profile prod/Deploy]
+role_arn                   = arn:aws:iam::123456789012:role/Deploy
+web_identity_token_file    = /var/run/secrets/token
+role_session_name          = ci
+common_fate_generated_from = github-actions
+common_fate_format_version = 1
+common_fate_generated_keys = role_arn,web_identity_token_file,role_session_name
+`)
+}
+
+func TestMerge_AssumeRoleFromWebIdentityProfile(t *testing.T) {
+	ci, err := NewWebIdentityProfile("arn:aws:iam::123456789012:role/Deploy", "/var/run/secrets/token", "github-actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := parseIni(t, "")
+	err = Merge(MergeOpts{
+		Config: cfg,
+		Profiles: []SSOProfile{
+			ci,
+			&AssumeRoleProfile{AccountName: "prod", AccountID: "210987654321", RoleName: "Release", SourceAccountID: "123456789012", SourceRoleName: "Deploy", GeneratedFrom: "github-actions"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "123456789012/Deploy", cfg.Section("profile prod/Release").Key("source_profile").String())
+}